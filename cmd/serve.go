@@ -2,16 +2,20 @@ package cmd
 
 import (
 	"context"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/tylerauerbeck/kured-silencer/pkg/metrics"
 	"github.com/tylerauerbeck/kured-silencer/pkg/server"
 )
 
 var (
-	defaultDuration = 15
+	defaultDuration = 15 * time.Minute
 )
 
 var serveCmd = &cobra.Command{
@@ -34,14 +38,123 @@ func init() {
 	serveCmd.Flags().String("alertmanager-endpoint", "", "Alertmanager endpoint to send silences to")
 	viperBindFlag("alertmanager-endpoint", serveCmd.Flags().Lookup("alertmanager-endpoint"))
 
+	serveCmd.Flags().Int("alertmanager-retry-attempts", 5, "attempts a failed alertmanager operation gets before it is given up on, 1 disables retries")
+	viperBindFlag("alertmanager-retry-attempts", serveCmd.Flags().Lookup("alertmanager-retry-attempts"))
+
+	serveCmd.Flags().Duration("alertmanager-retry-backoff", time.Second, "delay before retrying a failed alertmanager operation, doubling with every retry")
+	viperBindFlag("alertmanager-retry-backoff", serveCmd.Flags().Lookup("alertmanager-retry-backoff"))
+
+	serveCmd.Flags().Duration("alertmanager-retry-max-backoff", time.Minute, "maximum delay between retries of a failed alertmanager operation")
+	viperBindFlag("alertmanager-retry-max-backoff", serveCmd.Flags().Lookup("alertmanager-retry-max-backoff"))
+
+	serveCmd.Flags().Int("alertmanager-retry-queue-size", 100, "maximum number of alertmanager operations waiting for a retry")
+	viperBindFlag("alertmanager-retry-queue-size", serveCmd.Flags().Lookup("alertmanager-retry-queue-size"))
+
+	serveCmd.Flags().Int("alertmanager-breaker-threshold", 5, "consecutive failures after which calls to an alertmanager endpoint fail right away, 0 disables the circuit breaker")
+	viperBindFlag("alertmanager-breaker-threshold", serveCmd.Flags().Lookup("alertmanager-breaker-threshold"))
+
+	serveCmd.Flags().Duration("alertmanager-breaker-cooldown", 30*time.Second, "how long calls to a failing alertmanager endpoint fail right away before trying it again")
+	viperBindFlag("alertmanager-breaker-cooldown", serveCmd.Flags().Lookup("alertmanager-breaker-cooldown"))
+
+	serveCmd.Flags().String("journal", server.JournalNone, "where alertmanager operations waiting for a retry are persisted to be replayed after a restart: none, configmap or file, requires a configmap or lease state store")
+	viperBindFlag("journal", serveCmd.Flags().Lookup("journal"))
+
+	serveCmd.Flags().String("journal-name", "kured-silencer-journal", "name of the configmap used as journal, in the namespace of the state store")
+	viperBindFlag("journal-name", serveCmd.Flags().Lookup("journal-name"))
+
+	serveCmd.Flags().String("journal-path", "/var/lib/kured-silencer/journal.json", "path of the file used as journal, it has to be on a volume to survive restarts")
+	viperBindFlag("journal-path", serveCmd.Flags().Lookup("journal-path"))
+
+	serveCmd.Flags().String("prometheus-endpoint", "", "Prometheus-compatible endpoint used to evaluate the removeWhen queries of silences")
+	viperBindFlag("prometheus-endpoint", serveCmd.Flags().Lookup("prometheus-endpoint"))
+
 	serveCmd.Flags().Duration("removal-buffer", time.Duration(1*time.Minute), "buffer time before removing a silence from a node")
 	viperBindFlag("removal-buffer", serveCmd.Flags().Lookup("removal-buffer"))
 
-	serveCmd.Flags().Duration("silence-duration", time.Duration(defaultDuration), "silence duration in minutes")
+	serveCmd.Flags().String("removal-strategy", server.RemovalStrategyBuffer, "when to remove silences after the removal buffer: buffer or alerts-resolved")
+	viperBindFlag("removal-strategy", serveCmd.Flags().Lookup("removal-strategy"))
+
+	serveCmd.Flags().Duration("removal-max-wait", 30*time.Minute, "maximum time to wait for silenced alerts to resolve before removing a silence")
+	viperBindFlag("removal-max-wait", serveCmd.Flags().Lookup("removal-max-wait"))
+
+	serveCmd.Flags().Duration("removal-poll-interval", 30*time.Second, "interval between checks whether silenced alerts have resolved")
+	viperBindFlag("removal-poll-interval", serveCmd.Flags().Lookup("removal-poll-interval"))
+
+	serveCmd.Flags().Bool("removal-wait-for-pods", false, "wait for DaemonSet and selected workload pods on the node to be ready before removing silences")
+	viperBindFlag("removal-wait-for-pods", serveCmd.Flags().Lookup("removal-wait-for-pods"))
+
+	serveCmd.Flags().String("removal-pod-selector", "", "label selector of workload pods to wait for besides DaemonSet pods")
+	viperBindFlag("removal-pod-selector", serveCmd.Flags().Lookup("removal-pod-selector"))
+
+	serveCmd.Flags().Duration("removal-pods-timeout", 10*time.Minute, "maximum time to wait for pods to be ready before removing silences")
+	viperBindFlag("removal-pods-timeout", serveCmd.Flags().Lookup("removal-pods-timeout"))
+
+	serveCmd.Flags().Duration("silence-duration", defaultDuration, "how long silences last before they are renewed")
 	viperBindFlag("silence-duration", serveCmd.Flags().Lookup("silence-duration"))
+
+	serveCmd.Flags().Duration("silence-max-duration", 2*time.Hour, "maximum time silences of a node that is still labelled are renewed for, 0 disables renewal")
+	viperBindFlag("silence-max-duration", serveCmd.Flags().Lookup("silence-max-duration"))
+
+	// kured cordons nodes before rebooting them, so unschedulable nodes are silenced by default
+	serveCmd.Flags().StringSlice("pre-checks", []string{server.PreCheckReady}, "checks a node has to pass before it is silenced, as [!]type[=name[:value]] with type ready, schedulable, condition or taint")
+	viperBindFlag("pre-checks", serveCmd.Flags().Lookup("pre-checks"))
+
+	serveCmd.Flags().Duration("pending-retry-interval", 30*time.Second, "how often labelled nodes that failed a pre-check are reconsidered, 0 only reconsiders them when they change")
+	viperBindFlag("pending-retry-interval", serveCmd.Flags().Lookup("pending-retry-interval"))
+
+	serveCmd.Flags().String("metrics-address", ":9090", "address metrics are served on, empty disables metrics")
+	viperBindFlag("metrics-address", serveCmd.Flags().Lookup("metrics-address"))
+
+	serveCmd.Flags().String("on-shutdown", server.OnShutdownKeep, "what happens to active silences on shutdown: keep or expire")
+	viperBindFlag("on-shutdown", serveCmd.Flags().Lookup("on-shutdown"))
+
+	serveCmd.Flags().Duration("shutdown-timeout", 30*time.Second, "maximum time in-flight alertmanager calls are given to complete on shutdown")
+	viperBindFlag("shutdown-timeout", serveCmd.Flags().Lookup("shutdown-timeout"))
+
+	serveCmd.Flags().Bool("leader-elect", true, "elect a single replica to handle nodes, also when running out of cluster")
+	viperBindFlag("leader-elect", serveCmd.Flags().Lookup("leader-elect"))
+
+	serveCmd.Flags().Bool("sharding", false, "split nodes between live replicas through a lease per replica instead of electing a leader, using the leader election settings")
+	viperBindFlag("sharding", serveCmd.Flags().Lookup("sharding"))
+
+	serveCmd.Flags().String("leader-elect-lock-name", "kured-silencer", "name of the lease used for leader election")
+	viperBindFlag("leader-elect-lock-name", serveCmd.Flags().Lookup("leader-elect-lock-name"))
+
+	serveCmd.Flags().String("leader-elect-namespace", "", "namespace of the leader election lease, defaults to POD_NAMESPACE or the service account namespace")
+	viperBindFlag("leader-elect-namespace", serveCmd.Flags().Lookup("leader-elect-namespace"))
+
+	serveCmd.Flags().String("leader-elect-identity", "", "identity of this replica in leader election, defaults to POD_NAME or the hostname")
+	viperBindFlag("leader-elect-identity", serveCmd.Flags().Lookup("leader-elect-identity"))
+
+	serveCmd.Flags().Duration("leader-elect-lease-duration", 15*time.Second, "how long non-leaders wait before trying to acquire the lease")
+	viperBindFlag("leader-elect-lease-duration", serveCmd.Flags().Lookup("leader-elect-lease-duration"))
+
+	serveCmd.Flags().Duration("leader-elect-renew-deadline", 10*time.Second, "how long the leader retries renewing the lease before stepping down")
+	viperBindFlag("leader-elect-renew-deadline", serveCmd.Flags().Lookup("leader-elect-renew-deadline"))
+
+	serveCmd.Flags().Duration("leader-elect-retry-period", 2*time.Second, "interval between leader election attempts")
+	viperBindFlag("leader-elect-retry-period", serveCmd.Flags().Lookup("leader-elect-retry-period"))
+
+	serveCmd.Flags().String("node-watcher", server.NodeWatcherInformer, "how labelled nodes are observed: informer or watch")
+	viperBindFlag("node-watcher", serveCmd.Flags().Lookup("node-watcher"))
+
+	serveCmd.Flags().Duration("reconcile-interval", 5*time.Minute, "interval between reconciling labelled nodes with active silences, 0 to disable")
+	viperBindFlag("reconcile-interval", serveCmd.Flags().Lookup("reconcile-interval"))
+
+	serveCmd.Flags().String("state-store", server.StateStoreMemory, "where to keep track of node silences: memory, configmap or lease")
+	viperBindFlag("state-store", serveCmd.Flags().Lookup("state-store"))
+
+	serveCmd.Flags().String("state-store-name", "kured-silencer-state", "name of the configmap or lease used to keep track of node silences")
+	viperBindFlag("state-store-name", serveCmd.Flags().Lookup("state-store-name"))
+
+	serveCmd.Flags().String("state-store-namespace", "", "namespace of the state store object (defaults to the pod namespace)")
+	viperBindFlag("state-store-namespace", serveCmd.Flags().Lookup("state-store-namespace"))
 }
 
 func serve(ctx context.Context) {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	logger.Infow("starting kured-silencer", "alertmanager", viper.GetString("alertmanager-endpoint"), "label", viper.GetString("kured-label"))
 
 	srv, err := server.NewServer(ctx, logger)
@@ -49,5 +162,15 @@ func serve(ctx context.Context) {
 		logger.Fatalw("error creating server", "error", err)
 	}
 
+	if addr := viper.GetString("metrics-address"); addr != "" {
+		go func() {
+			if err := metrics.Serve(ctx, addr); err != nil {
+				logger.Errorw("error serving metrics", "error", err)
+			}
+		}()
+	}
+
 	srv.Run(ctx)
+
+	logger.Info("kured-silencer stopped")
 }
@@ -0,0 +1,24 @@
+package alertmanager
+
+import "errors"
+
+var (
+	// ErrMissingMatchers is returned when a silence definition has no matchers
+	ErrMissingMatchers = errors.New("silence has no matchers")
+
+	// ErrMissingMatcherName is returned when a matcher is missing a label name
+	ErrMissingMatcherName = errors.New("matcher is missing a name")
+
+	// ErrEmptyMatcherValue is returned when a positive equality matcher has an empty value,
+	// which matches every alert without the label
+	ErrEmptyMatcherValue = errors.New("matcher value is empty")
+
+	// ErrInvalidMatcher is returned when a matcher expression cannot be parsed
+	ErrInvalidMatcher = errors.New("invalid matcher")
+
+	// ErrInvalidMatcherRegex is returned when a regex matcher value does not compile
+	ErrInvalidMatcherRegex = errors.New("invalid matcher regex")
+
+	// ErrInvalidTemplate is returned when a comment or matcher value template cannot be parsed or rendered
+	ErrInvalidTemplate = errors.New("invalid template")
+)
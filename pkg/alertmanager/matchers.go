@@ -0,0 +1,72 @@
+package alertmanager
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/prometheus/alertmanager/pkg/labels"
+)
+
+// ParseMatchers parses matchers written in the alertmanager matcher syntax, e.g.
+// {alertname=~"Kube.*",node="{{ .Name }}",severity!="info"}
+func ParseMatchers(s string) ([]Matcher, error) {
+	parsed, err := labels.ParseMatchers(s)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("%w: %s", ErrInvalidMatcher, s), err)
+	}
+
+	matchers := make([]Matcher, 0, len(parsed))
+
+	for _, m := range parsed {
+		matchers = append(matchers, Matcher{
+			Name:    m.Name,
+			Value:   m.Value,
+			Regex:   m.Type == labels.MatchRegexp || m.Type == labels.MatchNotRegexp,
+			Negated: m.Type == labels.MatchNotEqual || m.Type == labels.MatchNotRegexp,
+		})
+	}
+
+	return matchers, nil
+}
+
+// MatchersDecodeHook allows silence definitions to be configured using the alertmanager
+// matcher syntax. A silence may be given as a single matcher string, and its matchers
+// either as a single string or as a list mixing matcher strings and structured matchers.
+func MatchersDecodeHook() mapstructure.DecodeHookFuncType {
+	silenceType := reflect.TypeOf(Silence{})
+	matcherType := reflect.TypeOf(Matcher{})
+	matchersType := reflect.TypeOf([]Matcher{})
+
+	return func(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+		if from.Kind() != reflect.String {
+			return data, nil
+		}
+
+		switch to {
+		case silenceType:
+			matchers, err := ParseMatchers(data.(string))
+			if err != nil {
+				return nil, err
+			}
+
+			return Silence{Matchers: matchers}, nil
+		case matchersType:
+			return ParseMatchers(data.(string))
+		case matcherType:
+			matchers, err := ParseMatchers(data.(string))
+			if err != nil {
+				return nil, err
+			}
+
+			if len(matchers) != 1 {
+				return nil, fmt.Errorf("%w: expected a single matcher: %s", ErrInvalidMatcher, data)
+			}
+
+			return matchers[0], nil
+		default:
+			return data, nil
+		}
+	}
+}
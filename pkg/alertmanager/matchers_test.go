@@ -0,0 +1,129 @@
+package alertmanager_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/alertmanager"
+)
+
+func TestParseMatchers(t *testing.T) {
+	type testCase struct {
+		name           string
+		input          string
+		expected       []alertmanager.Matcher
+		expectedErrors []error
+	}
+
+	testCases := []testCase{
+		{
+			name:  "all match types",
+			input: `{alertname=~"Kube.*",node="{{ .Name }}",severity!="info",job!~"kube-.*"}`,
+			expected: []alertmanager.Matcher{
+				{Name: "alertname", Value: "Kube.*", Regex: true},
+				{Name: "node", Value: "{{ .Name }}"},
+				{Name: "severity", Value: "info", Negated: true},
+				{Name: "job", Value: "kube-.*", Regex: true, Negated: true},
+			},
+		},
+		{
+			name:  "escaped template quotes",
+			input: `zone="{{ index .Labels \"topology.kubernetes.io/zone\" }}"`,
+			expected: []alertmanager.Matcher{
+				{Name: "zone", Value: `{{ index .Labels "topology.kubernetes.io/zone" }}`},
+			},
+		},
+		{
+			name:           "bad format",
+			input:          `{alertname=="Watchdog"}`,
+			expectedErrors: []error{alertmanager.ErrInvalidMatcher},
+		},
+		{
+			name:           "invalid regex",
+			input:          `{alertname=~"Kube("}`,
+			expectedErrors: []error{alertmanager.ErrInvalidMatcher},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			matchers, err := alertmanager.ParseMatchers(tc.input)
+
+			if len(tc.expectedErrors) > 0 {
+				assert.Error(t, err)
+				for _, expectedError := range tc.expectedErrors {
+					assert.ErrorIs(t, err, expectedError)
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, matchers)
+			}
+		})
+	}
+}
+
+func TestMatchersDecodeHook(t *testing.T) {
+	config := []byte(`
+silences:
+  - '{alertname=~"Kube.*",node="{{ .Name }}"}'
+  - comment: "mixed"
+    matchers:
+      - 'severity!="info"'
+      - name: instance
+        value: "{{ .Name }}:9100"
+  - comment: "string"
+    matchers: 'job="node-exporter", node="{{ .Name }}"'
+`)
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(bytes.NewReader(config)))
+
+	silences := []alertmanager.Silence{}
+	err := v.UnmarshalKey("silences", &silences, viper.DecodeHook(alertmanager.MatchersDecodeHook()))
+	assert.NoError(t, err)
+
+	expected := []alertmanager.Silence{
+		{
+			Matchers: []alertmanager.Matcher{
+				{Name: "alertname", Value: "Kube.*", Regex: true},
+				{Name: "node", Value: "{{ .Name }}"},
+			},
+		},
+		{
+			Comment: "mixed",
+			Matchers: []alertmanager.Matcher{
+				{Name: "severity", Value: "info", Negated: true},
+				{Name: "instance", Value: "{{ .Name }}:9100"},
+			},
+		},
+		{
+			Comment: "string",
+			Matchers: []alertmanager.Matcher{
+				{Name: "job", Value: "node-exporter"},
+				{Name: "node", Value: "{{ .Name }}"},
+			},
+		},
+	}
+
+	assert.Equal(t, expected, silences)
+	assert.NoError(t, alertmanager.ValidateSilences(silences))
+
+	invalid := []byte(`
+silences:
+  - comment: "invalid"
+    matchers:
+      - 'severity=="info"'
+`)
+
+	v = viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(bytes.NewReader(invalid)))
+
+	// mapstructure flattens decode errors, so only the message is preserved
+	err = v.UnmarshalKey("silences", &silences, viper.DecodeHook(alertmanager.MatchersDecodeHook()))
+	assert.ErrorContains(t, err, "'[0].matchers[0]': "+alertmanager.ErrInvalidMatcher.Error())
+}
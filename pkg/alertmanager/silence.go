@@ -0,0 +1,277 @@
+package alertmanager
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/prometheus/alertmanager/pkg/labels"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/internal/utils"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// CreatedBy is the creator recorded on every silence posted by kured-silencer
+const CreatedBy = "kured-silencer"
+
+var (
+	defaultComment = "silenced for kured reboot"
+	nodeTag        = regexp.MustCompile(`\[` + CreatedBy + ` node=([^\]]+)\]$`)
+)
+
+// Matcher is a single label matcher of a silence definition
+type Matcher struct {
+	Name    string `mapstructure:"name"`
+	Value   string `mapstructure:"value"`
+	Regex   bool   `mapstructure:"regex"`
+	Negated bool   `mapstructure:"negated"`
+}
+
+// Silence is a silence definition, each definition is posted as its own silence
+type Silence struct {
+	Comment  string    `mapstructure:"comment"`
+	Matchers []Matcher `mapstructure:"matchers"`
+
+	// RemoveWhen is a PromQL expression that keeps the silence in place after the node
+	// lost its label for as long as the query returns any samples
+	RemoveWhen string `mapstructure:"removeWhen"`
+
+	// Node is the name of the node the silence was rendered for
+	Node string `mapstructure:"-"`
+}
+
+// Validate ensures that the silence definition can be posted to alertmanager. Templates
+// referring to node fields that do not exist are rejected, errors depending on the node
+// surface once the silence is rendered.
+func (s Silence) Validate() error {
+	if len(s.Matchers) == 0 {
+		return ErrMissingMatchers
+	}
+
+	if err := checkTemplate(s.Comment); err != nil {
+		return fmt.Errorf("comment: %w", err)
+	}
+
+	if err := checkTemplate(s.RemoveWhen); err != nil {
+		return fmt.Errorf("removeWhen: %w", err)
+	}
+
+	for _, m := range s.Matchers {
+		if m.Name == "" {
+			return ErrMissingMatcherName
+		}
+
+		if err := checkTemplate(m.Value); err != nil {
+			return fmt.Errorf("matcher %s: %w", m.Name, err)
+		}
+
+		// an empty value silences the alerts of every node that lacks the label, templated
+		// values are checked once they are rendered for a node
+		if m.Value == "" && !m.Regex && !m.Negated {
+			return fmt.Errorf("%w: %s", ErrEmptyMatcherValue, m.Name)
+		}
+
+		// templated values can only be checked once they are rendered for a node
+		if m.Regex && !isTemplate(m.Value) {
+			if _, err := regexp.Compile(m.Value); err != nil {
+				return errors.Join(fmt.Errorf("%w: %s", ErrInvalidMatcherRegex, m.Name), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidateSilences validates every silence definition, reporting the index of the first invalid one
+func ValidateSilences(silences []Silence) error {
+	for i, s := range silences {
+		if err := s.Validate(); err != nil {
+			return fmt.Errorf("silence %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// DefaultSilences returns a silence definition for each label commonly used to identify
+// the node an alert originated from. Alertmanager ANDs the matchers of a single
+// silence, so each label needs to be its own silence.
+func DefaultSilences() []Silence {
+	comment := defaultComment + " of node {{ .Name }}"
+
+	return []Silence{
+		{
+			Comment:  comment,
+			Matchers: []Matcher{{Name: "node", Value: "{{ .Name }}"}},
+		},
+		{
+			Comment:  comment,
+			Matchers: []Matcher{{Name: "kubernetes_node", Value: "{{ .Name }}"}},
+		},
+		{
+			Comment: comment,
+			Matchers: []Matcher{{
+				Name:  "instance",
+				Value: "({{ quoteMeta .Name }}{{ range .Status.Addresses }}{{ if ne .Address $.Name }}|{{ quoteMeta .Address }}{{ end }}{{ end }})(:[0-9]+)?",
+				Regex: true,
+			}},
+		},
+	}
+}
+
+// Render evaluates the comment and matcher value templates of the silence against the given node
+func (s Silence) Render(node *v1.Node) (Silence, error) {
+	comment, err := render(s.Comment, node)
+	if err != nil {
+		return Silence{}, err
+	}
+
+	removeWhen, err := render(s.RemoveWhen, node)
+	if err != nil {
+		return Silence{}, fmt.Errorf("removeWhen: %w", err)
+	}
+
+	rendered := Silence{
+		Comment:    comment,
+		Node:       node.Name,
+		Matchers:   make([]Matcher, 0, len(s.Matchers)),
+		RemoveWhen: removeWhen,
+	}
+
+	for _, m := range s.Matchers {
+		value, err := render(m.Value, node)
+		if err != nil {
+			return Silence{}, fmt.Errorf("matcher %s: %w", m.Name, err)
+		}
+
+		m.Value = value
+		rendered.Matchers = append(rendered.Matchers, m)
+	}
+
+	if err := rendered.Validate(); err != nil {
+		return Silence{}, err
+	}
+
+	return rendered, nil
+}
+
+// RenderSilences renders every silence definition against the given node
+func RenderSilences(silences []Silence, node *v1.Node) ([]Silence, error) {
+	rendered := make([]Silence, 0, len(silences))
+
+	for i, s := range silences {
+		r, err := s.Render(node)
+		if err != nil {
+			return nil, fmt.Errorf("silence %d: %w", i, err)
+		}
+
+		rendered = append(rendered, r)
+	}
+
+	return rendered, nil
+}
+
+// comment returns the silence comment, tagged with the node the silence was rendered for
+// so the silence can be traced back to its node
+func (s Silence) comment() string {
+	comment := s.Comment
+	if comment == "" {
+		comment = defaultComment
+	}
+
+	if s.Node == "" {
+		return comment
+	}
+
+	return fmt.Sprintf("%s [%s node=%s]", comment, CreatedBy, s.Node)
+}
+
+// nodeFromComment returns the node a silence was created for from its comment tag
+func nodeFromComment(comment string) (string, bool) {
+	m := nodeTag.FindStringSubmatch(comment)
+	if m == nil {
+		return "", false
+	}
+
+	return m[1], true
+}
+
+func (s Silence) models() models.Matchers {
+	matchers := models.Matchers{}
+
+	for _, m := range s.Matchers {
+		matchers = append(matchers, &models.Matcher{
+			IsEqual: utils.NewBool(!m.Negated),
+			IsRegex: utils.NewBool(m.Regex),
+			Name:    utils.NewString(m.Name),
+			Value:   utils.NewString(m.Value),
+		})
+	}
+
+	return matchers
+}
+
+// filter returns the matchers of the silence in the filter syntax of the alertmanager api
+func (s Silence) filter() ([]string, error) {
+	filter := []string{}
+
+	for _, m := range s.Matchers {
+		lm, err := labels.NewMatcher(m.matchType(), m.Name, m.Value)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("%w: %s", ErrInvalidMatcher, m.Name), err)
+		}
+
+		filter = append(filter, lm.String())
+	}
+
+	return filter, nil
+}
+
+// matches reports whether the given alertmanager matchers are identical to the ones of the silence
+func (s Silence) matches(matchers models.Matchers) bool {
+	if len(matchers) != len(s.Matchers) {
+		return false
+	}
+
+	for _, want := range s.models() {
+		found := false
+
+		for _, got := range matchers {
+			if got.Name == nil || got.Value == nil || got.IsRegex == nil {
+				continue
+			}
+
+			if *got.Name == *want.Name && *got.Value == *want.Value &&
+				*got.IsRegex == *want.IsRegex && isEqual(got) == isEqual(want) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m Matcher) matchType() labels.MatchType {
+	switch {
+	case m.Regex && m.Negated:
+		return labels.MatchNotRegexp
+	case m.Regex:
+		return labels.MatchRegexp
+	case m.Negated:
+		return labels.MatchNotEqual
+	default:
+		return labels.MatchEqual
+	}
+}
+
+// isEqual returns whether the matcher is a positive match, which is the default when unset
+func isEqual(m *models.Matcher) bool {
+	return m.IsEqual == nil || *m.IsEqual
+}
@@ -0,0 +1,258 @@
+package alertmanager_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/alertmanager"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateSilences(t *testing.T) {
+	type testCase struct {
+		name           string
+		silences       []alertmanager.Silence
+		expectedErrors []error
+	}
+
+	testCases := []testCase{
+		{
+			name: "valid",
+			silences: []alertmanager.Silence{
+				{
+					Comment: "node exporter",
+					Matchers: []alertmanager.Matcher{
+						{Name: "job", Value: "node-exporter"},
+						{Name: "severity", Value: "warning|critical", Regex: true},
+						{Name: "alertname", Value: "Watchdog", Negated: true},
+					},
+				},
+			},
+		},
+		{
+			name: "no definitions",
+		},
+		{
+			name: "valid template",
+			silences: []alertmanager.Silence{
+				{
+					Comment: "{{ .Name }} rebooting",
+					Matchers: []alertmanager.Matcher{
+						{Name: "node", Value: "{{ .Name }}"},
+						{Name: "instance", Value: "{{ quoteMeta .Name }}:.*", Regex: true},
+					},
+				},
+			},
+		},
+		{
+			name: "invalid comment template",
+			silences: []alertmanager.Silence{
+				{
+					Comment:  "{{ .Name ",
+					Matchers: []alertmanager.Matcher{{Name: "node", Value: "node-1"}},
+				},
+			},
+			expectedErrors: []error{alertmanager.ErrInvalidTemplate},
+		},
+		{
+			name: "invalid removeWhen template",
+			silences: []alertmanager.Silence{
+				{
+					RemoveWhen: `up{node="{{ .Name "}`,
+					Matchers:   []alertmanager.Matcher{{Name: "node", Value: "node-1"}},
+				},
+			},
+			expectedErrors: []error{alertmanager.ErrInvalidTemplate},
+		},
+		{
+			name: "invalid matcher template",
+			silences: []alertmanager.Silence{
+				{Matchers: []alertmanager.Matcher{{Name: "node", Value: "{{ unknown .Name }}"}}},
+			},
+			expectedErrors: []error{alertmanager.ErrInvalidTemplate},
+		},
+		{
+			name: "unknown template field",
+			silences: []alertmanager.Silence{
+				{Matchers: []alertmanager.Matcher{{Name: "node", Value: "{{ .Nmae }}"}}},
+			},
+			expectedErrors: []error{alertmanager.ErrInvalidTemplate},
+		},
+		{
+			name: "node dependent template",
+			silences: []alertmanager.Silence{
+				{
+					Comment: "{{ .Labels.zone }}",
+					Matchers: []alertmanager.Matcher{
+						{Name: "node", Value: "{{ .Name }}"},
+						{Name: "instance", Value: "{{ (index .Status.Addresses 0).Address }}"},
+					},
+				},
+			},
+		},
+		{
+			name: "empty value",
+			silences: []alertmanager.Silence{
+				{Matchers: []alertmanager.Matcher{{Name: "job", Value: "node-exporter"}, {Name: "zone"}}},
+			},
+			expectedErrors: []error{alertmanager.ErrEmptyMatcherValue},
+		},
+		{
+			name: "empty negated value",
+			silences: []alertmanager.Silence{
+				{Matchers: []alertmanager.Matcher{{Name: "job", Value: "node-exporter"}, {Name: "zone", Negated: true}}},
+			},
+		},
+		{
+			name:           "no matchers",
+			silences:       []alertmanager.Silence{{Comment: "empty"}},
+			expectedErrors: []error{alertmanager.ErrMissingMatchers},
+		},
+		{
+			name: "missing name",
+			silences: []alertmanager.Silence{
+				{Matchers: []alertmanager.Matcher{{Value: "node-1"}}},
+			},
+			expectedErrors: []error{alertmanager.ErrMissingMatcherName},
+		},
+		{
+			name: "invalid regex",
+			silences: []alertmanager.Silence{
+				{Matchers: []alertmanager.Matcher{{Name: "node", Value: "node-(", Regex: true}}},
+			},
+			expectedErrors: []error{alertmanager.ErrInvalidMatcherRegex},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := alertmanager.ValidateSilences(tc.silences)
+
+			if len(tc.expectedErrors) > 0 {
+				assert.Error(t, err)
+				for _, expectedError := range tc.expectedErrors {
+					assert.ErrorIs(t, err, expectedError)
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRenderSilences(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+			Labels: map[string]string{
+				"topology.kubernetes.io/zone": "zone-a",
+			},
+		},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeHostName, Address: "node-1"},
+				{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+			},
+		},
+	}
+
+	type testCase struct {
+		name           string
+		silences       []alertmanager.Silence
+		expected       []alertmanager.Silence
+		expectedErrors []error
+	}
+
+	testCases := []testCase{
+		{
+			name:     "defaults",
+			silences: alertmanager.DefaultSilences(),
+			expected: []alertmanager.Silence{
+				{
+					Comment:  "silenced for kured reboot of node node-1",
+					Matchers: []alertmanager.Matcher{{Name: "node", Value: "node-1"}},
+					Node:     "node-1",
+				},
+				{
+					Comment:  "silenced for kured reboot of node node-1",
+					Matchers: []alertmanager.Matcher{{Name: "kubernetes_node", Value: "node-1"}},
+					Node:     "node-1",
+				},
+				{
+					Comment:  "silenced for kured reboot of node node-1",
+					Matchers: []alertmanager.Matcher{{Name: "instance", Value: `(node-1|10\.0\.0\.1)(:[0-9]+)?`, Regex: true}},
+					Node:     "node-1",
+				},
+			},
+		},
+		{
+			name: "labels and addresses",
+			silences: []alertmanager.Silence{
+				{
+					Comment:    "zone {{ index .Labels \"topology.kubernetes.io/zone\" }}",
+					RemoveWhen: `up{job="node-exporter",node="{{ .Name }}"} == 0`,
+					Matchers: []alertmanager.Matcher{
+						{Name: "zone", Value: "{{ index .Labels \"topology.kubernetes.io/zone\" }}"},
+						{Name: "instance", Value: "{{ (index .Status.Addresses 1).Address }}:9100"},
+					},
+				},
+			},
+			expected: []alertmanager.Silence{
+				{
+					Comment: "zone zone-a",
+					Matchers: []alertmanager.Matcher{
+						{Name: "zone", Value: "zone-a"},
+						{Name: "instance", Value: "10.0.0.1:9100"},
+					},
+					Node:       "node-1",
+					RemoveWhen: `up{job="node-exporter",node="node-1"} == 0`,
+				},
+			},
+		},
+		{
+			name: "execution error",
+			silences: []alertmanager.Silence{
+				{Matchers: []alertmanager.Matcher{{Name: "instance", Value: "{{ (index .Status.Addresses 5).Address }}"}}},
+			},
+			expectedErrors: []error{alertmanager.ErrInvalidTemplate},
+		},
+		{
+			name: "rendered empty value",
+			silences: []alertmanager.Silence{
+				{
+					Matchers: []alertmanager.Matcher{
+						{Name: "job", Value: "node-exporter"},
+						{Name: "rack", Value: "{{ index .Labels \"topology.kubernetes.io/rack\" }}"},
+					},
+				},
+			},
+			expectedErrors: []error{alertmanager.ErrEmptyMatcherValue},
+		},
+		{
+			name: "rendered invalid regex",
+			silences: []alertmanager.Silence{
+				{Matchers: []alertmanager.Matcher{{Name: "node", Value: "{{ .Name }}(", Regex: true}}},
+			},
+			expectedErrors: []error{alertmanager.ErrInvalidMatcherRegex},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rendered, err := alertmanager.RenderSilences(tc.silences, node)
+
+			if len(tc.expectedErrors) > 0 {
+				assert.Error(t, err)
+				for _, expectedError := range tc.expectedErrors {
+					assert.ErrorIs(t, err, expectedError)
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, rendered)
+			}
+		})
+	}
+}
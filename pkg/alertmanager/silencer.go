@@ -12,6 +12,7 @@ import (
 	"github.com/tylerauerbeck/kured-silencer/pkg/internal/utils"
 
 	"github.com/prometheus/alertmanager/api/v2/client"
+	"github.com/prometheus/alertmanager/api/v2/client/alert"
 	"github.com/prometheus/alertmanager/api/v2/client/silence"
 	"github.com/prometheus/alertmanager/api/v2/models"
 )
@@ -24,68 +25,190 @@ func NewSilencerClient(_ context.Context, u *url.URL) *client.AlertmanagerAPI {
 	)
 }
 
-// PostSilence creates a new silence for all warning and critical alerts
-func PostSilence(ctx context.Context, cli *client.AlertmanagerAPI, duration time.Duration) ([]string, error) {
-	// TODO: allow for defining custom matchers?
+// PostSilence creates a new silence for each of the provided silence definitions. If an
+// active silence created by kured-silencer with identical matchers already exists, it is
+// reused and extended to cover the requested duration instead.
+func PostSilence(ctx context.Context, cli *client.AlertmanagerAPI, silences []Silence, duration time.Duration) ([]string, error) {
 	ids := []string{}
 
-	warning := []*models.Matcher{
-		{
-			IsRegex: utils.NewBool(true),
-			Name:    utils.NewString("severity"),
-			Value:   utils.NewString("warning"),
-		},
-	}
+	for _, s := range silences {
+		endsAt := time.Now().Add(duration)
+
+		existing, err := findSilence(ctx, cli, s)
+		if err != nil {
+			return ids, err
+		}
 
-	params := silence.NewPostSilencesParamsWithContext(ctx).
-		WithSilence(&models.PostableSilence{
+		ps := &models.PostableSilence{
 			Silence: models.Silence{
 				StartsAt:  utils.NewDateTime(strfmt.DateTime(time.Now())),
-				EndsAt:    utils.NewDateTime(strfmt.DateTime(time.Now().Add(duration))),
-				Comment:   utils.NewString("silenced for kured reboot"),
-				CreatedBy: utils.NewString("kured-silencer"),
-				Matchers:  warning,
+				EndsAt:    utils.NewDateTime(strfmt.DateTime(endsAt)),
+				Comment:   utils.NewString(s.comment()),
+				CreatedBy: utils.NewString(CreatedBy),
+				Matchers:  s.models(),
 			},
-		})
+		}
+
+		if existing != nil {
+			if time.Time(*existing.EndsAt).After(endsAt) {
+				ids = append(ids, *existing.ID)
+				continue
+			}
+
+			ps.ID = *existing.ID
+			ps.StartsAt = existing.StartsAt
+		}
+
+		id, err := cli.Silence.PostSilences(silence.NewPostSilencesParamsWithContext(ctx).WithSilence(ps))
+		if err != nil {
+			return ids, err
+		}
+
+		ids = append(ids, id.Payload.SilenceID)
+	}
+
+	return ids, nil
+}
+
+// findSilence returns an active silence created by kured-silencer for the same node with
+// the same matchers as the given silence definition, or nil if there is none. Silences of
+// other nodes are never reused, each node expires its silences on its own.
+func findSilence(ctx context.Context, cli *client.AlertmanagerAPI, s Silence) (*models.GettableSilence, error) {
+	filter, err := s.filter()
+	if err != nil {
+		return nil, err
+	}
 
-	id, err := cli.Silence.PostSilences(params)
+	resp, err := cli.Silence.GetSilences(silence.NewGetSilencesParamsWithContext(ctx).WithFilter(filter))
 	if err != nil {
-		return ids, err
+		return nil, err
+	}
+
+	for _, gs := range resp.Payload {
+		if gs.CreatedBy == nil || *gs.CreatedBy != CreatedBy {
+			continue
+		}
+
+		if gs.Status == nil || gs.Status.State == nil || *gs.Status.State != models.SilenceStatusStateActive {
+			continue
+		}
+
+		if gs.Comment == nil {
+			continue
+		}
+
+		if node, _ := nodeFromComment(*gs.Comment); node != s.Node {
+			continue
+		}
+
+		if s.matches(gs.Matchers) {
+			return gs, nil
+		}
 	}
 
-	ids = append(ids, id.Payload.SilenceID)
+	return nil, nil
+}
 
-	critical := []*models.Matcher{
-		{
-			IsRegex: utils.NewBool(true),
-			Name:    utils.NewString("severity"),
-			Value:   utils.NewString("critical"),
-		},
+// ListNodeSilences returns the ids of the active silences created by kured-silencer,
+// grouped by the node they were created for
+func ListNodeSilences(ctx context.Context, cli *client.AlertmanagerAPI) (map[string][]string, error) {
+	silences, err := NodeSilences(ctx, cli)
+	if err != nil {
+		return nil, err
 	}
 
-	params = silence.NewPostSilencesParamsWithContext(ctx).
-		WithSilence(&models.PostableSilence{
-			Silence: models.Silence{
-				StartsAt:  utils.NewDateTime(strfmt.DateTime(time.Now())),
-				EndsAt:    utils.NewDateTime(strfmt.DateTime(time.Now().Add(duration))),
-				Comment:   utils.NewString("silenced for kured reboot"),
-				CreatedBy: utils.NewString("kured-silencer"),
-				Matchers:  critical,
-			},
-		})
+	nodes := make(map[string][]string, len(silences))
 
-	// TODO: maybe break up the generation of params and actually calling the API?
+	for node, ss := range silences {
+		for _, gs := range ss {
+			nodes[node] = append(nodes[node], *gs.ID)
+		}
+	}
 
-	// TODO: lets not add another silencer if there is already one in place
+	return nodes, nil
+}
 
-	id, err = cli.Silence.PostSilences(params)
+// NodeSilences returns the active silences created by kured-silencer, grouped by the node
+// they were created for
+func NodeSilences(ctx context.Context, cli *client.AlertmanagerAPI) (map[string]models.GettableSilences, error) {
+	resp, err := cli.Silence.GetSilences(silence.NewGetSilencesParamsWithContext(ctx))
 	if err != nil {
-		return ids, err
+		return nil, err
 	}
 
-	ids = append(ids, id.Payload.SilenceID)
+	nodes := make(map[string]models.GettableSilences)
 
-	return ids, nil
+	for _, gs := range resp.Payload {
+		if gs.CreatedBy == nil || *gs.CreatedBy != CreatedBy || gs.Comment == nil {
+			continue
+		}
+
+		if gs.Status == nil || gs.Status.State == nil || *gs.Status.State == models.SilenceStatusStateExpired {
+			continue
+		}
+
+		node, ok := nodeFromComment(*gs.Comment)
+		if !ok {
+			continue
+		}
+
+		nodes[node] = append(nodes[node], gs)
+	}
+
+	return nodes, nil
+}
+
+// RemoveWhen returns the removeWhen queries of the given silences, keyed by silence id. The
+// query of each silence is taken from the rendered definition with identical matchers,
+// silences without a query are left out.
+func RemoveWhen(silences models.GettableSilences, rendered []Silence) map[string]string {
+	queries := make(map[string]string)
+
+	for _, gs := range silences {
+		if gs.ID == nil {
+			continue
+		}
+
+		for _, s := range rendered {
+			if s.RemoveWhen != "" && s.matches(gs.Matchers) {
+				queries[*gs.ID] = s.RemoveWhen
+				break
+			}
+		}
+	}
+
+	return queries
+}
+
+// ExtendSilence moves the end of the silence with the specified id to endsAt and returns
+// the id of the updated silence, which differs from id if alertmanager had to replace it
+func ExtendSilence(ctx context.Context, cli *client.AlertmanagerAPI, id string, endsAt time.Time) (string, error) {
+	resp, err := cli.Silence.GetSilence(silence.NewGetSilenceParamsWithContext(ctx).WithSilenceID(strfmt.UUID(id)))
+	if err != nil {
+		return "", err
+	}
+
+	gs := resp.Payload
+
+	ps := &models.PostableSilence{
+		ID:      *gs.ID,
+		Silence: gs.Silence,
+	}
+
+	ps.EndsAt = utils.NewDateTime(strfmt.DateTime(endsAt))
+
+	// an expired silence cannot be updated, a new one is created in its place
+	if gs.Status != nil && gs.Status.State != nil && *gs.Status.State == models.SilenceStatusStateExpired {
+		ps.ID = ""
+		ps.StartsAt = utils.NewDateTime(strfmt.DateTime(time.Now()))
+	}
+
+	posted, err := cli.Silence.PostSilences(silence.NewPostSilencesParamsWithContext(ctx).WithSilence(ps))
+	if err != nil {
+		return "", err
+	}
+
+	return posted.Payload.SilenceID, nil
 }
 
 // DeleteSilence deletes the silence with the specified id
@@ -99,3 +222,38 @@ func DeleteSilence(ctx context.Context, cli *client.AlertmanagerAPI, id string)
 
 	return nil
 }
+
+// SilencedAlerts returns the alerts that are still firing and silenced by any of the given silences
+func SilencedAlerts(ctx context.Context, cli *client.AlertmanagerAPI, ids []string) ([]*models.GettableAlert, error) {
+	params := alert.NewGetAlertsParamsWithContext(ctx).
+		WithActive(utils.NewBool(true)).
+		WithSilenced(utils.NewBool(true)).
+		WithInhibited(utils.NewBool(true))
+
+	resp, err := cli.Alert.GetAlerts(params)
+	if err != nil {
+		return nil, err
+	}
+
+	silences := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		silences[id] = true
+	}
+
+	alerts := []*models.GettableAlert{}
+
+	for _, a := range resp.Payload {
+		if a.Status == nil {
+			continue
+		}
+
+		for _, id := range a.Status.SilencedBy {
+			if silences[id] {
+				alerts = append(alerts, a)
+				break
+			}
+		}
+	}
+
+	return alerts, nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/go-openapi/strfmt"
 	"github.com/stretchr/testify/assert"
@@ -13,6 +14,9 @@ import (
 	"github.com/prometheus/alertmanager/api/v2/client/silence"
 
 	"github.com/tylerauerbeck/kured-silencer/pkg/alertmanager"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestSilenceWorkflow(t *testing.T) {
@@ -27,22 +31,167 @@ func TestSilenceWorkflow(t *testing.T) {
 	c := alertmanager.NewSilencerClient(context.TODO(), u)
 	assert.NotNil(t, c)
 
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+		},
+	}
+
 	ctx := context.Background()
-	id, err := alertmanager.PostSilence(ctx, c, 5)
+	silences, err := alertmanager.RenderSilences(alertmanager.DefaultSilences(), node)
 	assert.NoError(t, err)
-	assert.NotEmpty(t, id)
 
-	s, err := getSilence(ctx, c, id)
+	ids, err := alertmanager.PostSilence(ctx, c, silences, 5*time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, ids, len(silences))
+
+	for _, id := range ids {
+		s, err := getSilence(ctx, c, id)
+		assert.NoError(t, err)
+		assert.NotNil(t, s)
+		assert.Equal(t, len(s.Payload.Matchers), 1)
+
+		err = alertmanager.DeleteSilence(ctx, c, id)
+		assert.NoError(t, err)
+
+		s, err = getSilence(ctx, c, id)
+		assert.NoError(t, err)
+		assert.Equal(t, *s.Payload.Status.State, "expired")
+	}
+}
+
+func TestPostSilenceReusesExisting(t *testing.T) {
+	endpoint, err := AMContainer.Endpoint(context.Background(), "")
+	if err != nil {
+		t.Error(err)
+	}
+
+	u, err := url.Parse(fmt.Sprintf("http://%s", endpoint))
+	assert.NoError(t, err)
+
+	c := alertmanager.NewSilencerClient(context.TODO(), u)
+
+	silences := []alertmanager.Silence{
+		{
+			Comment:  "reuse",
+			Matchers: []alertmanager.Matcher{{Name: "node", Value: "node-reuse"}},
+		},
+	}
+
+	ctx := context.Background()
+	ids, err := alertmanager.PostSilence(ctx, c, silences, 5*time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, ids, 1)
+
+	s, err := getSilence(ctx, c, ids[0])
+	assert.NoError(t, err)
+
+	extended, err := alertmanager.PostSilence(ctx, c, silences, 10*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, ids, extended)
+
+	e, err := getSilence(ctx, c, extended[0])
+	assert.NoError(t, err)
+	assert.True(t, time.Time(*e.Payload.EndsAt).After(time.Time(*s.Payload.EndsAt)))
+
+	// a silence with a superset of the matchers is not reused
+	silences[0].Matchers = append(silences[0].Matchers, alertmanager.Matcher{Name: "severity", Value: "critical"})
+
+	other, err := alertmanager.PostSilence(ctx, c, silences, 5*time.Minute)
+	assert.NoError(t, err)
+	assert.NotEqual(t, ids, other)
+
+	// the silence of another node with the same matchers is not reused
+	silences[0].Node = "node-other"
+
+	node, err := alertmanager.PostSilence(ctx, c, silences, 5*time.Minute)
+	assert.NoError(t, err)
+	assert.NotEqual(t, other, node)
+
+	for _, id := range append(append(ids, other...), node...) {
+		assert.NoError(t, alertmanager.DeleteSilence(ctx, c, id))
+	}
+}
+
+func TestListNodeSilences(t *testing.T) {
+	endpoint, err := AMContainer.Endpoint(context.Background(), "")
+	if err != nil {
+		t.Error(err)
+	}
+
+	u, err := url.Parse(fmt.Sprintf("http://%s", endpoint))
+	assert.NoError(t, err)
+
+	c := alertmanager.NewSilencerClient(context.TODO(), u)
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-list",
+		},
+	}
+
+	silences, err := alertmanager.RenderSilences(alertmanager.DefaultSilences(), node)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	ids, err := alertmanager.PostSilence(ctx, c, silences, 5*time.Minute)
+	assert.NoError(t, err)
+
+	nodes, err := alertmanager.ListNodeSilences(ctx, c)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, ids, nodes["node-list"])
+
+	for _, id := range ids {
+		assert.NoError(t, alertmanager.DeleteSilence(ctx, c, id))
+	}
+
+	nodes, err = alertmanager.ListNodeSilences(ctx, c)
+	assert.NoError(t, err)
+	assert.NotContains(t, nodes, "node-list")
+}
+
+func TestExtendSilence(t *testing.T) {
+	endpoint, err := AMContainer.Endpoint(context.Background(), "")
+	if err != nil {
+		t.Error(err)
+	}
+
+	u, err := url.Parse(fmt.Sprintf("http://%s", endpoint))
 	assert.NoError(t, err)
-	assert.NotNil(t, s)
-	assert.Equal(t, len(s.Payload.Matchers), 2)
 
-	err = alertmanager.DeleteSilence(ctx, c, id)
+	c := alertmanager.NewSilencerClient(context.TODO(), u)
+
+	silences := []alertmanager.Silence{
+		{
+			Comment:  "extend",
+			Matchers: []alertmanager.Matcher{{Name: "node", Value: "node-extend"}},
+		},
+	}
+
+	ctx := context.Background()
+	ids, err := alertmanager.PostSilence(ctx, c, silences, 5*time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, ids, 1)
+
+	endsAt := time.Now().Add(time.Hour)
+
+	id, err := alertmanager.ExtendSilence(ctx, c, ids[0], endsAt)
 	assert.NoError(t, err)
+	assert.Equal(t, ids[0], id)
 
-	s, err = getSilence(ctx, c, id)
-	assert.Equal(t, *s.Payload.Status.State, "expired")
+	s, err := getSilence(ctx, c, id)
 	assert.NoError(t, err)
+	assert.WithinDuration(t, endsAt, time.Time(*s.Payload.EndsAt), time.Second)
+	assert.Equal(t, "extend", *s.Payload.Comment)
+
+	// an expired silence is replaced
+	assert.NoError(t, alertmanager.DeleteSilence(ctx, c, id))
+
+	replaced, err := alertmanager.ExtendSilence(ctx, c, id, endsAt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, id, replaced)
+
+	assert.NoError(t, alertmanager.DeleteSilence(ctx, c, replaced))
 }
 
 func getSilence(ctx context.Context, cli *client.AlertmanagerAPI, id string) (*silence.GetSilenceOK, error) {
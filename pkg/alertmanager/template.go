@@ -0,0 +1,72 @@
+package alertmanager
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"text/template"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+var (
+	templateFuncs = template.FuncMap{
+		"quoteMeta": regexp.QuoteMeta,
+	}
+)
+
+// isTemplate reports whether the value contains any template actions
+func isTemplate(value string) bool {
+	return strings.Contains(value, "{{")
+}
+
+// parseTemplate parses the value as a template that is later executed against a node
+func parseTemplate(value string) (*template.Template, error) {
+	t, err := template.New("").Funcs(templateFuncs).Option("missingkey=error").Parse(value)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidTemplate, err)
+	}
+
+	return t, nil
+}
+
+// checkTemplate parses the value template and executes it against an empty node, so
+// references to fields that do not exist fail before the template is rendered for any
+// node. Other execution errors, such as indexing the empty addresses, depend on the node
+// and are only reported when the template is rendered.
+func checkTemplate(value string) error {
+	if !isTemplate(value) {
+		return nil
+	}
+
+	t, err := parseTemplate(value)
+	if err != nil {
+		return err
+	}
+
+	if err := t.Execute(io.Discard, &v1.Node{}); err != nil && strings.Contains(err.Error(), "can't evaluate field") {
+		return errors.Join(ErrInvalidTemplate, err)
+	}
+
+	return nil
+}
+
+// render executes the value template against the given node
+func render(value string, node *v1.Node) (string, error) {
+	if !isTemplate(value) {
+		return value, nil
+	}
+
+	t, err := parseTemplate(value)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, node); err != nil {
+		return "", errors.Join(ErrInvalidTemplate, err)
+	}
+
+	return b.String(), nil
+}
@@ -3,12 +3,20 @@ package kube
 import (
 	"context"
 	"errors"
+	"time"
 
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 )
 
 var (
@@ -39,10 +47,19 @@ func NewKubeClient(_ context.Context, path string) (*kubernetes.Clientset, error
 
 // NewNodeWatcher returns a new node watcher for nodes with the specified label
 func NewNodeWatcher(ctx context.Context, cli kubernetes.Interface, label string) (watch.Interface, error) {
-	// TODO: add bookmarker here so that it's not picking up old labels
-	// This is particularly important if it just keeps failing and sees
-	// an existing label and then just adds another new silencer
-	watcher, err := cli.CoreV1().Nodes().Watch(ctx, metav1.ListOptions{LabelSelector: label, TimeoutSeconds: &timeoutSeconds})
+	return ResumeNodeWatcher(ctx, cli, label, "")
+}
+
+// ResumeNodeWatcher returns a new node watcher for nodes with the specified label that
+// starts after the given resource version. Bookmarks are requested so the resource
+// version keeps advancing even when no labelled node changes.
+func ResumeNodeWatcher(ctx context.Context, cli kubernetes.Interface, label, resourceVersion string) (watch.Interface, error) {
+	watcher, err := cli.CoreV1().Nodes().Watch(ctx, metav1.ListOptions{
+		LabelSelector:       label,
+		TimeoutSeconds:      &timeoutSeconds,
+		ResourceVersion:     resourceVersion,
+		AllowWatchBookmarks: true,
+	})
 	if err != nil {
 		// TODO: errInvalidWatcher
 		return nil, err
@@ -50,3 +67,50 @@ func NewNodeWatcher(ctx context.Context, cli kubernetes.Interface, label string)
 
 	return watcher, nil
 }
+
+// NewNodeInformer returns a shared informer for nodes with the specified label
+func NewNodeInformer(cli kubernetes.Interface, label string, resync time.Duration) cache.SharedIndexInformer {
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = label
+			return cli.CoreV1().Nodes().List(context.Background(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = label
+			return cli.CoreV1().Nodes().Watch(context.Background(), options)
+		},
+	}
+
+	return cache.NewSharedIndexInformer(lw, &v1.Node{}, resync, cache.Indexers{})
+}
+
+// NewNodePodInformer returns an informer for the pods bound to the specified node
+func NewNodePodInformer(cli kubernetes.Interface, node string) cache.SharedIndexInformer {
+	selector := fields.OneTermEqualSelector("spec.nodeName", node).String()
+
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return cli.CoreV1().Pods(metav1.NamespaceAll).List(context.Background(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return cli.CoreV1().Pods(metav1.NamespaceAll).Watch(context.Background(), options)
+		},
+	}
+
+	return cache.NewSharedIndexInformer(lw, &v1.Pod{}, 0, cache.Indexers{})
+}
+
+// ListNodes returns the nodes with the specified label
+func ListNodes(ctx context.Context, cli kubernetes.Interface, label string) (*v1.NodeList, error) {
+	return cli.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: label})
+}
+
+// NewEventRecorder returns a recorder that emits kubernetes events as the given component
+func NewEventRecorder(cli kubernetes.Interface, component string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: cli.CoreV1().Events("")})
+
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: component})
+}
@@ -10,8 +10,12 @@ import (
 	"github.com/tylerauerbeck/kured-silencer/pkg/kube"
 	"github.com/tylerauerbeck/kured-silencer/pkg/server"
 
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
 )
 
 func TestNewNodeWatcher(t *testing.T) {
@@ -32,6 +36,52 @@ func TestNewNodeWatcher(t *testing.T) {
 	assert.Nil(t, watcher)
 }
 
+func TestResumeNodeWatcher(t *testing.T) {
+	cli := fake.NewSimpleClientset()
+
+	var restrictions k8stesting.WatchRestrictions
+
+	cli.PrependWatchReactor("nodes", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		restrictions = action.(k8stesting.WatchActionImpl).GetWatchRestrictions()
+		return false, nil, nil
+	})
+
+	watcher, err := kube.ResumeNodeWatcher(context.TODO(), cli, "hello=world", "42")
+	assert.NoError(t, err)
+	assert.NotNil(t, watcher)
+	assert.Equal(t, "42", restrictions.ResourceVersion)
+	assert.Equal(t, "hello=world", restrictions.Labels.String())
+}
+
+func TestNewNodeInformer(t *testing.T) {
+	cli := fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "labelled", Labels: map[string]string{"hello": "world"}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "unlabelled"}},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	informer := kube.NewNodeInformer(cli, "hello=world", 0)
+
+	go informer.Run(ctx.Done())
+
+	assert.True(t, cache.WaitForCacheSync(ctx.Done(), informer.HasSynced))
+	assert.Equal(t, []string{"labelled"}, informer.GetIndexer().ListKeys())
+}
+
+func TestListNodes(t *testing.T) {
+	cli := fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "labelled", Labels: map[string]string{"hello": "world"}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "unlabelled"}},
+	)
+
+	nodes, err := kube.ListNodes(context.TODO(), cli, "hello=world")
+	assert.NoError(t, err)
+	assert.Len(t, nodes.Items, 1)
+	assert.Equal(t, "labelled", nodes.Items[0].Name)
+}
+
 func TestNewKubeClient(t *testing.T) {
 	type testCase struct {
 		name           string
@@ -0,0 +1,2 @@
+// Package metrics provides the prometheus metrics exposed by kured-silencer
+package metrics
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "kured_silencer"
+
+var (
+	// NodeDeletions counts silenced nodes that were deleted from the cluster
+	NodeDeletions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "node_deletions_total",
+		Help:      "Number of silenced nodes deleted from the cluster whose silences were expired.",
+	})
+
+	// AlertmanagerRetries counts failed alertmanager operations queued for another attempt
+	AlertmanagerRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "alertmanager_retries_total",
+		Help:      "Number of failed alertmanager operations queued for another attempt.",
+	}, []string{"operation"})
+
+	// AlertmanagerFailures counts alertmanager operations given up on after their retries
+	AlertmanagerFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "alertmanager_permanent_failures_total",
+		Help:      "Number of alertmanager operations given up on after exhausting their retries.",
+	}, []string{"operation"})
+
+	// AlertmanagerCircuitOpen is 1 while calls to an alertmanager endpoint fail right away
+	AlertmanagerCircuitOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "alertmanager_circuit_open",
+		Help:      "Whether the circuit of an alertmanager endpoint is open after repeated failures.",
+	}, []string{"endpoint"})
+)
+
+// Serve exposes the registered metrics on /metrics at the given address until ctx is cancelled
+func Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
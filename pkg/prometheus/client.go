@@ -0,0 +1,68 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+// Client queries a Prometheus-compatible api
+type Client struct {
+	endpoint *url.URL
+	http     *http.Client
+}
+
+type queryResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string            `json:"resultType"`
+		Result     []json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// NewClient returns a new client for the api at the specified url
+func NewClient(_ context.Context, u *url.URL) *Client {
+	return &Client{
+		endpoint: u,
+		http:     http.DefaultClient,
+	}
+}
+
+// Query evaluates the instant query and returns the number of samples in the resulting vector
+func (c *Client) Query(ctx context.Context, query string) (int, error) {
+	u := *c.endpoint
+	u.Path = path.Join(u.Path, "/api/v1/query")
+	u.RawQuery = url.Values{"query": []string{query}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	qr := queryResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&qr); err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrQueryFailed, resp.Status)
+	}
+
+	if qr.Status != "success" {
+		return 0, fmt.Errorf("%w: %s: %s", ErrQueryFailed, qr.ErrorType, qr.Error)
+	}
+
+	if qr.Data.ResultType != "vector" {
+		return 0, fmt.Errorf("%w: %s", ErrUnexpectedResultType, qr.Data.ResultType)
+	}
+
+	return len(qr.Data.Result), nil
+}
@@ -0,0 +1,90 @@
+package prometheus_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/prometheus"
+)
+
+func TestQuery(t *testing.T) {
+	responses := map[string]string{
+		`up{node="empty"} == 0`:  `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+		`up{node="firing"} == 0`: `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"node":"firing"},"value":[1,"0"]},{"metric":{"node":"firing"},"value":[1,"0"]}]}}`,
+		`scalar(up)`:             `{"status":"success","data":{"resultType":"scalar","result":[1,"1"]}}`,
+		`up{`:                    `{"status":"error","errorType":"bad_data","error":"parse error"}`,
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/prom/api/v1/query", r.URL.Path)
+
+		resp, ok := responses[r.URL.Query().Get("query")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_, _ = w.Write([]byte(resp))
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL + "/prom")
+	assert.NoError(t, err)
+
+	c := prometheus.NewClient(context.TODO(), u)
+
+	type testCase struct {
+		name           string
+		query          string
+		expected       int
+		expectedErrors []error
+	}
+
+	testCases := []testCase{
+		{
+			name:  "empty vector",
+			query: `up{node="empty"} == 0`,
+		},
+		{
+			name:     "vector",
+			query:    `up{node="firing"} == 0`,
+			expected: 2,
+		},
+		{
+			name:           "scalar",
+			query:          `scalar(up)`,
+			expectedErrors: []error{prometheus.ErrUnexpectedResultType},
+		},
+		{
+			name:           "query error",
+			query:          `up{`,
+			expectedErrors: []error{prometheus.ErrQueryFailed},
+		},
+		{
+			name:           "not found",
+			query:          `missing`,
+			expectedErrors: []error{prometheus.ErrQueryFailed},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := c.Query(context.TODO(), tc.query)
+
+			if len(tc.expectedErrors) > 0 {
+				assert.Error(t, err)
+				for _, expectedError := range tc.expectedErrors {
+					assert.ErrorIs(t, err, expectedError)
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,2 @@
+// Package prometheus provides the logic for querying a Prometheus-compatible api
+package prometheus
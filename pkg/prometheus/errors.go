@@ -0,0 +1,11 @@
+package prometheus
+
+import "errors"
+
+var (
+	// ErrQueryFailed is returned when the api responds with an error status
+	ErrQueryFailed = errors.New("query failed")
+
+	// ErrUnexpectedResultType is returned when a query does not evaluate to a vector
+	ErrUnexpectedResultType = errors.New("unexpected result type")
+)
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/kube"
+	"github.com/tylerauerbeck/kured-silencer/pkg/metrics"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// deletionRun observes every node regardless of the kured label, so nodes deleted from
+// the cluster can be told apart from nodes that lost the label. It returns once the
+// informer is started, the informer stops when ctx is cancelled.
+func (srv Server) deletionRun(ctx, work context.Context) error {
+	informer := kube.NewNodeInformer(srv.GetKubeClient(), "", 0)
+
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+
+			node, ok := obj.(*v1.Node)
+			if !ok {
+				return
+			}
+
+			if err := srv.NodeDeleted(work, node.Name); err != nil && !errors.Is(err, ErrRetryQueued) {
+				srv.logger.Errorw("unable to expire silences of deleted node", "node", node.Name, "error", err)
+			}
+		},
+	}); err != nil {
+		return err
+	}
+
+	go informer.Run(ctx.Done())
+
+	return nil
+}
+
+// NodeDeleted expires the silences of a node that was deleted from the cluster right
+// away, skipping the removal buffer and strategy, so they do not suppress alerts for a
+// replacement node reusing the name
+func (srv Server) NodeDeleted(ctx context.Context, name string) error {
+	if !srv.shard.owns(name) {
+		return nil
+	}
+
+	srv.pending.remove(name)
+	srv.removals.cancel(name)
+	srv.retries.drop(ctx, name)
+
+	state, ok, err := srv.state.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return nil
+	}
+
+	if err := srv.expireSilences(ctx, name, state.SilenceIDs); err != nil {
+		return srv.retryExpire(ctx, name, err)
+	}
+
+	metrics.NodeDeletions.Inc()
+	srv.logger.Infow("node deleted, silences expired", "node", name, "silences", state.SilenceIDs)
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/metrics"
+	"github.com/tylerauerbeck/kured-silencer/pkg/server"
+
+	"go.uber.org/zap"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNodeDeleted(t *testing.T) {
+	ctx := context.Background()
+
+	fam, amc := newFakeAlertManager(t, func() models.GettableAlerts { return models.GettableAlerts{} })
+
+	state := server.NewMemoryStateStore()
+
+	srv := server.Server{
+		Client: &server.Client{
+			KubeClient: fake.NewSimpleClientset(),
+			AMClient:   amc,
+		},
+	}.WithLogger(ctx, zap.NewNop().Sugar()).
+		WithStateStore(ctx, state).
+		WithRemovalBuffer(ctx, time.Hour)
+
+	deletions := testutil.ToFloat64(metrics.NodeDeletions)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	assert.NoError(t, state.Set(ctx, node.Name, server.NodeState{SilenceIDs: []string{"id-1", "id-2"}}))
+
+	// the label watch sees the deletion as the label being removed first
+	assert.NoError(t, srv.EventHandler(ctx, watch.Event{Type: watch.Deleted, Object: node}))
+	assert.Empty(t, fam.deletedSilences())
+
+	// deleted nodes skip the removal buffer
+	assert.NoError(t, srv.NodeDeleted(ctx, node.Name))
+	assert.Equal(t, []string{"id-1", "id-2"}, fam.deletedSilences())
+
+	_, ok, err := state.Get(ctx, node.Name)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.Equal(t, deletions+1, testutil.ToFloat64(metrics.NodeDeletions))
+
+	// nodes that were never silenced are ignored
+	assert.NoError(t, srv.NodeDeleted(ctx, "node-2"))
+	assert.Equal(t, deletions+1, testutil.ToFloat64(metrics.NodeDeletions))
+}
@@ -20,4 +20,56 @@ var (
 
 	// ErrNodeUnschedulable is returned when the node is unschedulable
 	ErrNodeUnschedulable = errors.New("node unschedulable")
+
+	// ErrUnknownStateStore is returned when the configured state store type is not supported
+	ErrUnknownStateStore = errors.New("unknown state store")
+
+	// ErrMissingNamespace is returned when a namespaced object is needed but no namespace is configured
+	ErrMissingNamespace = errors.New("missing namespace")
+
+	// ErrCacheSync is returned when the node informer cache fails to sync
+	ErrCacheSync = errors.New("failed to sync node cache")
+
+	// ErrUnknownNodeWatcher is returned when the configured node watcher is not supported
+	ErrUnknownNodeWatcher = errors.New("unknown node watcher")
+
+	// ErrUnknownRemovalStrategy is returned when the configured removal strategy is not supported
+	ErrUnknownRemovalStrategy = errors.New("unknown removal strategy")
+
+	// ErrMissingPrometheusEndpoint is returned when a silence has a removeWhen query but no prometheus endpoint is configured
+	ErrMissingPrometheusEndpoint = errors.New("removeWhen queries require a prometheus endpoint")
+
+	// ErrInvalidPodSelector is returned when the selector of workload pods to wait for is invalid
+	ErrInvalidPodSelector = errors.New("invalid pod selector")
+
+	// ErrInvalidPreCheck is returned when a pre-check is invalid
+	ErrInvalidPreCheck = errors.New("invalid pre-check")
+
+	// ErrPreCheckFailed is returned when a node does not pass a pre-check
+	ErrPreCheckFailed = errors.New("node failed pre-check")
+
+	// ErrUnknownShutdownAction is returned when the configured shutdown action is not supported
+	ErrUnknownShutdownAction = errors.New("unknown shutdown action")
+
+	// ErrInvalidLeaderElection is returned when leader election is enabled with an invalid configuration
+	ErrInvalidLeaderElection = errors.New("invalid leader election configuration")
+
+	// ErrInvalidRetryPolicy is returned when the retry policy of alertmanager calls is invalid
+	ErrInvalidRetryPolicy = errors.New("invalid retry policy")
+
+	// ErrCircuitOpen is returned for alertmanager calls to an endpoint that keeps failing
+	ErrCircuitOpen = errors.New("alertmanager circuit open")
+
+	// ErrRetryQueued is returned when a failed alertmanager operation is queued for another attempt
+	ErrRetryQueued = errors.New("queued for retry")
+
+	// ErrUnknownJournal is returned when the configured journal type is not supported
+	ErrUnknownJournal = errors.New("unknown journal")
+
+	// ErrMissingJournalPath is returned when the file journal is configured without a path
+	ErrMissingJournalPath = errors.New("missing journal path")
+
+	// ErrJournalWithoutStateStore is returned when a journal is configured with the memory
+	// state store, which can not tell journaled operations that are stale after a restart
+	ErrJournalWithoutStateStore = errors.New("journal requires a configmap or lease state store")
 )
@@ -0,0 +1,17 @@
+package server
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// eventf emits a kubernetes event for the node, if the server has an event recorder
+func (srv Server) eventf(node, eventType, reason, messageFmt string, args ...interface{}) {
+	if srv.recorder == nil {
+		return
+	}
+
+	ref := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: node}}
+
+	srv.recorder.Eventf(ref, eventType, reason, messageFmt, args...)
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/spf13/viper"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/kube"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// NodeWatcherInformer observes nodes through a shared informer and a rate limited workqueue
+	NodeWatcherInformer = "informer"
+
+	// NodeWatcherWatch observes nodes through a plain watch
+	NodeWatcherWatch = "watch"
+
+	// maxRetries is the number of times a node is retried before it is dropped from the queue
+	maxRetries = 5
+)
+
+// informerRun observes labelled nodes through a shared informer, queueing the name of
+// each node that changes. Queued nodes are handled by comparing the informer cache with
+// the state store, so repeated or missed events for a node collapse into a single update.
+func (srv *Server) informerRun(ctx, work context.Context) error {
+	informer := kube.NewNodeInformer(srv.GetKubeClient(), viper.GetString("kured-label"), 0)
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+
+	defer queue.ShutDown()
+
+	enqueue := func(obj interface{}) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			srv.logger.Errorw("unable to queue node", "error", err)
+			return
+		}
+
+		queue.Add(key)
+	}
+
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+		DeleteFunc: enqueue,
+	}); err != nil {
+		return err
+	}
+
+	go informer.Run(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return ErrCacheSync
+	}
+
+	// nodes that lost the label while the informer was not running are not in the cache
+	// and only queued through the state store
+	nodes, err := srv.state.List(work)
+	if err != nil {
+		srv.logger.Errorw("unable to list stored node state", "error", err)
+	}
+
+	for node := range nodes {
+		queue.Add(node)
+	}
+
+	worker := make(chan struct{})
+
+	go func() {
+		defer close(worker)
+
+		for srv.processNextNode(work, queue, informer.GetIndexer()) {
+		}
+	}()
+
+	// the node being handled when ctx is cancelled is finished before returning
+	defer func() {
+		queue.ShutDown()
+		<-worker
+	}()
+
+	reconcile, stop := srv.reconcileTicker()
+	defer stop()
+
+	renew, stopRenew := srv.renewalTicker()
+	defer stopRenew()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-reconcile:
+			srv.runReconcile(work)
+		case <-srv.shard.changed():
+			// take over the nodes of replicas that left
+			srv.runReconcile(work)
+		case <-renew:
+			_ = srv.RenewSilences(work)
+		}
+	}
+}
+
+// processNextNode handles the next queued node, returning false once the queue is shut down
+func (srv *Server) processNextNode(ctx context.Context, queue workqueue.RateLimitingInterface, indexer cache.Indexer) bool {
+	item, shutdown := queue.Get()
+	if shutdown {
+		return false
+	}
+
+	defer queue.Done(item)
+
+	key := item.(string)
+
+	err := srv.EventHandler(ctx, nodeEvent(key, indexer))
+
+	switch {
+	case err == nil, errors.Is(err, ErrMissingNode), errors.Is(err, ErrRetryQueued):
+		// queued retries are attempted by the retry queue
+		queue.Forget(item)
+	case errors.Is(err, ErrNodeNotReady), errors.Is(err, ErrNodeUnschedulable), errors.Is(err, ErrPreCheckFailed):
+		// updates to the node queue it again, the delay covers nodes that pass without changing
+		queue.Forget(item)
+
+		if srv.pendingInterval > 0 {
+			queue.AddAfter(item, srv.pendingInterval)
+		}
+	case queue.NumRequeues(item) < maxRetries:
+		srv.logger.Warnw("retrying node", "node", key, "error", err)
+		queue.AddRateLimited(item)
+	default:
+		srv.logger.Errorw("dropping node after retries", "node", key, "error", err)
+		queue.Forget(item)
+	}
+
+	return true
+}
+
+// nodeEvent builds the event for the queued node from the informer cache, nodes that are
+// no longer cached have either been deleted or lost the label
+func nodeEvent(key string, indexer cache.Indexer) watch.Event {
+	obj, exists, err := indexer.GetByKey(key)
+	if err == nil && exists {
+		return watch.Event{Type: watch.Added, Object: obj.(*v1.Node)}
+	}
+
+	return watch.Event{
+		Type:   watch.Deleted,
+		Object: &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: key}},
+	}
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// JournalNone only keeps queued alertmanager operations in memory, they are lost on
+	// restart or failover
+	JournalNone = "none"
+
+	// JournalConfigMap journals queued alertmanager operations in a ConfigMap, one key per
+	// operation
+	JournalConfigMap = "configmap"
+
+	// JournalFile journals queued alertmanager operations in a local file, which has to be on
+	// a volume to survive restarts
+	JournalFile = "file"
+)
+
+// JournalEntry is an alertmanager operation on the silences of a node waiting for a retry
+type JournalEntry struct {
+	// Seq orders the entries by when they were queued
+	Seq       uint64 `json:"seq"`
+	Operation string `json:"operation"`
+	Node      string `json:"node"`
+	// Version identifies the silences stored for the node when the operation was last
+	// attempted, the operation is dropped once they changed
+	Version  string    `json:"version"`
+	Attempts int       `json:"attempts"`
+	QueuedAt time.Time `json:"queuedAt"`
+
+	next time.Time
+}
+
+// key identifies the entry, a node has at most one entry per operation
+func (e JournalEntry) key() string {
+	return e.Operation + "." + e.Node
+}
+
+// Journal persists the alertmanager operations waiting for a retry, so they are replayed
+// after a restart or failover. Implementations must be safe for concurrent use.
+type Journal interface {
+	// Load returns the journaled entries in the order they were queued
+	Load(ctx context.Context) ([]JournalEntry, error)
+	// Put stores the entry, replacing the entry of the same operation on the same node
+	Put(ctx context.Context, entry JournalEntry) error
+	// Delete removes the entry unless it was replaced by a newer one
+	Delete(ctx context.Context, entry JournalEntry) error
+}
+
+// NewJournal returns the journal of the given kind. The ConfigMap journal is the named
+// ConfigMap in the given namespace, the file journal is the file at path.
+func NewJournal(kind string, cli kubernetes.Interface, namespace, name, path string) (Journal, error) {
+	switch kind {
+	case JournalNone, "":
+		return nil, nil
+	case JournalConfigMap:
+		if namespace == "" {
+			return nil, ErrMissingNamespace
+		}
+
+		return NewConfigMapJournal(cli, namespace, name), nil
+	case JournalFile:
+		if path == "" {
+			return nil, ErrMissingJournalPath
+		}
+
+		return NewFileJournal(path), nil
+	default:
+		return nil, ErrUnknownJournal
+	}
+}
+
+// journalObject is where the entries of a journal are read from and written back to as a whole
+type journalObject interface {
+	// load reads the entries, keyed by operation and node
+	load(ctx context.Context) (map[string]JournalEntry, error)
+	// save writes the entries back to the last loaded version of the object
+	save(ctx context.Context, entries map[string]JournalEntry) error
+}
+
+type objectJournal struct {
+	mu     sync.Mutex
+	object journalObject
+}
+
+// NewConfigMapJournal returns a journal that keeps each entry as a key of the named ConfigMap
+func NewConfigMapJournal(cli kubernetes.Interface, namespace, name string) Journal {
+	return &objectJournal{
+		object: &configMapJournalObject{cli: cli, namespace: namespace, name: name},
+	}
+}
+
+// NewFileJournal returns a journal that keeps the entries in the file at path
+func NewFileJournal(path string) Journal {
+	return &objectJournal{
+		object: &fileJournalObject{path: path},
+	}
+}
+
+func (j *objectJournal) Load(ctx context.Context) ([]JournalEntry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	stored, err := j.object.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]JournalEntry, 0, len(stored))
+	for _, e := range stored {
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Seq < entries[b].Seq })
+
+	return entries, nil
+}
+
+func (j *objectJournal) Put(ctx context.Context, entry JournalEntry) error {
+	return j.update(ctx, func(entries map[string]JournalEntry) {
+		entries[entry.key()] = entry
+	})
+}
+
+func (j *objectJournal) Delete(ctx context.Context, entry JournalEntry) error {
+	return j.update(ctx, func(entries map[string]JournalEntry) {
+		if stored, ok := entries[entry.key()]; ok && stored.Seq <= entry.Seq {
+			delete(entries, entry.key())
+		}
+	})
+}
+
+func (j *objectJournal) update(ctx context.Context, fn func(map[string]JournalEntry)) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		entries, err := j.object.load(ctx)
+		if err != nil {
+			return err
+		}
+
+		fn(entries)
+
+		return j.object.save(ctx, entries)
+	})
+}
+
+type configMapJournalObject struct {
+	cli       kubernetes.Interface
+	namespace string
+	name      string
+	cm        *v1.ConfigMap
+}
+
+func (o *configMapJournalObject) load(ctx context.Context) (map[string]JournalEntry, error) {
+	cm, err := o.cli.CoreV1().ConfigMaps(o.namespace).Get(ctx, o.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm, err = o.cli.CoreV1().ConfigMaps(o.namespace).Create(ctx, &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: o.name, Namespace: o.namespace},
+		}, metav1.CreateOptions{})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	o.cm = cm
+
+	entries := make(map[string]JournalEntry, len(cm.Data))
+
+	for key, data := range cm.Data {
+		entry := JournalEntry{}
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return nil, err
+		}
+
+		entries[key] = entry
+	}
+
+	return entries, nil
+}
+
+func (o *configMapJournalObject) save(ctx context.Context, entries map[string]JournalEntry) error {
+	cm := o.cm.DeepCopy()
+	cm.Data = make(map[string]string, len(entries))
+
+	for key, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		cm.Data[key] = string(data)
+	}
+
+	cm, err := o.cli.CoreV1().ConfigMaps(o.namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+
+	o.cm = cm
+
+	return nil
+}
+
+type fileJournalObject struct {
+	path string
+}
+
+func (o *fileJournalObject) load(_ context.Context) (map[string]JournalEntry, error) {
+	entries := make(map[string]JournalEntry)
+
+	data, err := os.ReadFile(o.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return entries, nil
+	}
+
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// save replaces the file through a rename, so a crash while writing leaves the previous
+// entries in place
+func (o *fileJournalObject) save(_ context.Context, entries map[string]JournalEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(o.path), 0o750); err != nil {
+		return err
+	}
+
+	tmp := o.path + ".tmp"
+
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, o.path)
+}
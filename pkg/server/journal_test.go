@@ -0,0 +1,188 @@
+package server_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/server"
+
+	"go.uber.org/zap"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestJournals(t *testing.T) {
+	type testCase struct {
+		name    string
+		journal func(cli *fake.Clientset, path string) server.Journal
+	}
+
+	testCases := []testCase{
+		{
+			name: server.JournalConfigMap,
+			journal: func(cli *fake.Clientset, _ string) server.Journal {
+				return server.NewConfigMapJournal(cli, "kured", "kured-silencer-journal")
+			},
+		},
+		{
+			name: server.JournalFile,
+			journal: func(_ *fake.Clientset, path string) server.Journal {
+				return server.NewFileJournal(path)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			cli := fake.NewSimpleClientset()
+			path := filepath.Join(t.TempDir(), "journal", "journal.json")
+
+			journal := tc.journal(cli, path)
+
+			entries, err := journal.Load(ctx)
+			assert.NoError(t, err)
+			assert.Empty(t, entries)
+
+			queuedAt := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+
+			expire := server.JournalEntry{Seq: 2, Operation: "expire", Node: "node-1", Version: "[id-1]", Attempts: 1, QueuedAt: queuedAt}
+			silence := server.JournalEntry{Seq: 1, Operation: "silence", Node: "node-2", Attempts: 3, QueuedAt: queuedAt}
+
+			assert.NoError(t, journal.Put(ctx, expire))
+			assert.NoError(t, journal.Put(ctx, silence))
+
+			// entries are loaded in the order they were queued, also by a second journal
+			entries, err = journal.Load(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, []server.JournalEntry{silence, expire}, entries)
+
+			entries, err = tc.journal(cli, path).Load(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, []server.JournalEntry{silence, expire}, entries)
+
+			// an entry queued again is not removed by deleting the entry it replaced
+			requeued := expire
+			requeued.Seq = 3
+
+			assert.NoError(t, journal.Put(ctx, requeued))
+			assert.NoError(t, journal.Delete(ctx, expire))
+
+			entries, err = journal.Load(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, []server.JournalEntry{silence, requeued}, entries)
+
+			assert.NoError(t, journal.Delete(ctx, requeued))
+			assert.NoError(t, journal.Delete(ctx, silence))
+
+			entries, err = journal.Load(ctx)
+			assert.NoError(t, err)
+			assert.Empty(t, entries)
+		})
+	}
+}
+
+func TestNewJournal(t *testing.T) {
+	cli := fake.NewSimpleClientset()
+
+	_, err := server.NewJournal("etcd", cli, "kured", "journal", "")
+	assert.ErrorIs(t, err, server.ErrUnknownJournal)
+
+	_, err = server.NewJournal(server.JournalConfigMap, cli, "", "journal", "")
+	assert.ErrorIs(t, err, server.ErrMissingNamespace)
+
+	_, err = server.NewJournal(server.JournalFile, cli, "kured", "journal", "")
+	assert.ErrorIs(t, err, server.ErrMissingJournalPath)
+
+	journal, err := server.NewJournal(server.JournalNone, cli, "", "", "")
+	assert.NoError(t, err)
+	assert.Nil(t, journal)
+}
+
+func TestJournalReplay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kcli := fake.NewSimpleClientset()
+	journal := server.NewConfigMapJournal(kcli, "kured", "kured-silencer-journal")
+	state := server.NewConfigMapStateStore(kcli, "kured", "kured-silencer-state")
+
+	fam, amc := newFakeAlertManager(t, func() models.GettableAlerts { return models.GettableAlerts{} })
+	fam.nodeSilence("id-1", "node-1")
+	fam.nodeSilence("id-2", "node-2")
+	fam.setUnavailable(true)
+
+	assert.NoError(t, state.Set(ctx, "node-1", server.NodeState{SilenceIDs: []string{"id-1"}}))
+	assert.NoError(t, state.Set(ctx, "node-2", server.NodeState{SilenceIDs: []string{"id-2"}}))
+
+	newServer := func() *server.Server {
+		return server.Server{
+			Client: &server.Client{
+				KubeClient: kcli,
+				AMClient:   amc,
+			},
+		}.WithLogger(ctx, zap.NewNop().Sugar()).
+			WithStateStore(ctx, state).
+			WithRemovalBuffer(ctx, time.Hour).
+			WithRetryPolicy(ctx, server.RetryPolicy{
+				MaxAttempts:    5,
+				InitialBackoff: 10 * time.Millisecond,
+				MaxBackoff:     40 * time.Millisecond,
+				QueueSize:      10,
+			}).
+			WithJournal(ctx, journal)
+	}
+
+	// the operations are journaled by a replica that stops before alertmanager is back
+	srv := newServer()
+
+	assert.ErrorIs(t, srv.NodeDeleted(ctx, "node-1"), server.ErrRetryQueued)
+	assert.ErrorIs(t, srv.NodeDeleted(ctx, "node-2"), server.ErrRetryQueued)
+
+	entries, err := journal.Load(ctx)
+	assert.NoError(t, err)
+
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "node-1", entries[0].Node)
+		assert.Equal(t, "node-2", entries[1].Node)
+	}
+
+	// node-2 was silenced again in the meantime, its expiry is stale
+	fam.nodeSilence("id-3", "node-2")
+	assert.NoError(t, state.Set(ctx, "node-2", server.NodeState{SilenceIDs: []string{"id-2", "id-3"}}))
+
+	fam.setUnavailable(false)
+
+	srv = newServer()
+
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		srv.Run(ctx)
+	}()
+
+	assert.Eventually(t, func() bool {
+		entries, err := journal.Load(ctx)
+		return err == nil && len(entries) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, []string{"id-1"}, fam.deletedSilences())
+
+	_, ok, err := state.Get(ctx, "node-1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ns, ok, err := state.Get(ctx, "node-2")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.ElementsMatch(t, []string{"id-2", "id-3"}, ns.SilenceIDs)
+
+	cancel()
+	<-stopped
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+var (
+	// serviceAccountNamespaceFile holds the namespace of the pod when running in cluster
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// LeaderElection configures the election of the replica that handles nodes
+type LeaderElection struct {
+	// Enabled runs leader election, otherwise the server handles nodes on its own
+	Enabled bool
+	// LockName is the name of the Lease used as lock
+	LockName string
+	// Namespace is the namespace of the Lease
+	Namespace string
+	// Identity identifies this replica as holder of the lease
+	Identity string
+
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// newLeaderElection returns the configured leader election, resolving an empty namespace
+// and identity from the environment
+func newLeaderElection() (LeaderElection, error) {
+	le := LeaderElection{
+		Enabled:       viper.GetBool("leader-elect"),
+		LockName:      viper.GetString("leader-elect-lock-name"),
+		Namespace:     podNamespace(viper.GetString("leader-elect-namespace")),
+		Identity:      viper.GetString("leader-elect-identity"),
+		LeaseDuration: viper.GetDuration("leader-elect-lease-duration"),
+		RenewDeadline: viper.GetDuration("leader-elect-renew-deadline"),
+		RetryPeriod:   viper.GetDuration("leader-elect-retry-period"),
+	}
+
+	if le.Identity == "" {
+		le.Identity = podName()
+	}
+
+	return le, le.Validate()
+}
+
+// Validate ensures an enabled leader election has a lock and identity, and timings the
+// leader elector accepts
+func (le LeaderElection) Validate() error {
+	if !le.Enabled {
+		return nil
+	}
+
+	switch {
+	case le.Namespace == "":
+		return fmt.Errorf("%w: %w", ErrInvalidLeaderElection, ErrMissingNamespace)
+	case le.LockName == "":
+		return fmt.Errorf("%w: missing lock name", ErrInvalidLeaderElection)
+	case le.Identity == "":
+		return fmt.Errorf("%w: missing identity", ErrInvalidLeaderElection)
+	case le.LeaseDuration <= le.RenewDeadline:
+		return fmt.Errorf("%w: lease duration must be greater than renew deadline", ErrInvalidLeaderElection)
+	case le.RenewDeadline <= time.Duration(leaderelection.JitterFactor*float64(le.RetryPeriod)):
+		return fmt.Errorf("%w: renew deadline must be greater than %v times the retry period", ErrInvalidLeaderElection, leaderelection.JitterFactor)
+	}
+
+	return nil
+}
+
+// lock returns the Lease lock of the election
+func (le LeaderElection) lock(cli kubernetes.Interface) *resourcelock.LeaseLock {
+	return &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      le.LockName,
+			Namespace: le.Namespace,
+		},
+		Client: cli.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: le.Identity,
+		},
+	}
+}
+
+// podNamespace returns the configured namespace, falling back to the POD_NAMESPACE
+// environment variable and the namespace of the service account
+func podNamespace(configured string) string {
+	if configured != "" {
+		return configured
+	}
+
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+
+	if ns, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		return strings.TrimSpace(string(ns))
+	}
+
+	return ""
+}
+
+// podName returns the POD_NAME environment variable, falling back to the hostname
+func podName() string {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+
+	name, _ := os.Hostname()
+
+	return name
+}
@@ -0,0 +1,80 @@
+package server_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/server"
+)
+
+func TestLeaderElectionValidate(t *testing.T) {
+	valid := server.LeaderElection{
+		Enabled:       true,
+		LockName:      "kured-silencer",
+		Namespace:     "kube-system",
+		Identity:      "kured-silencer-0",
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   2 * time.Second,
+	}
+
+	type testCase struct {
+		name           string
+		modify         func(le *server.LeaderElection)
+		expectedErrors []error
+	}
+
+	testCases := []testCase{
+		{
+			name:   "valid",
+			modify: func(le *server.LeaderElection) {},
+		},
+		{
+			name: "disabled without namespace",
+			modify: func(le *server.LeaderElection) {
+				le.Enabled = false
+				le.Namespace = ""
+			},
+		},
+		{
+			name:           "missing namespace",
+			modify:         func(le *server.LeaderElection) { le.Namespace = "" },
+			expectedErrors: []error{server.ErrInvalidLeaderElection, server.ErrMissingNamespace},
+		},
+		{
+			name:           "missing identity",
+			modify:         func(le *server.LeaderElection) { le.Identity = "" },
+			expectedErrors: []error{server.ErrInvalidLeaderElection},
+		},
+		{
+			name:           "renew deadline exceeds lease duration",
+			modify:         func(le *server.LeaderElection) { le.RenewDeadline = 20 * time.Second },
+			expectedErrors: []error{server.ErrInvalidLeaderElection},
+		},
+		{
+			name:           "retry period too long for renew deadline",
+			modify:         func(le *server.LeaderElection) { le.RetryPeriod = 9 * time.Second },
+			expectedErrors: []error{server.ErrInvalidLeaderElection},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			le := valid
+			tc.modify(&le)
+
+			err := le.Validate()
+
+			if len(tc.expectedErrors) > 0 {
+				assert.Error(t, err)
+				for _, expectedError := range tc.expectedErrors {
+					assert.ErrorIs(t, err, expectedError)
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/kube"
+
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// pendingNodes is the set of labelled nodes that failed a pre-check and are reconsidered
+// until they pass or lose the label. Its methods are safe to call on a nil set.
+type pendingNodes struct {
+	mu    sync.Mutex
+	nodes map[string]struct{}
+}
+
+func newPendingNodes() *pendingNodes {
+	return &pendingNodes{nodes: make(map[string]struct{})}
+}
+
+func (p *pendingNodes) add(node string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nodes[node] = struct{}{}
+}
+
+// remove drops the node from the set, returning whether it was pending
+func (p *pendingNodes) remove(node string) bool {
+	if p == nil {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.nodes[node]
+	delete(p.nodes, node)
+
+	return ok
+}
+
+func (p *pendingNodes) has(node string) bool {
+	if p == nil {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.nodes[node]
+
+	return ok
+}
+
+// list returns the sorted names of the pending nodes
+func (p *pendingNodes) list() []string {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	nodes := make([]string, 0, len(p.nodes))
+	for node := range p.nodes {
+		nodes = append(nodes, node)
+	}
+
+	sort.Strings(nodes)
+
+	return nodes
+}
+
+// pendingTicker returns a channel that fires every pending retry interval, or nil if
+// pending nodes are only reconsidered when they change
+func (srv Server) pendingTicker() (<-chan time.Time, func()) {
+	if srv.pendingInterval <= 0 {
+		return nil, func() {}
+	}
+
+	t := time.NewTicker(srv.pendingInterval)
+
+	return t.C, t.Stop
+}
+
+// RetryPending re-evaluates the nodes that failed a pre-check when they were labelled,
+// silencing those that pass now and forgetting those that lost the label
+func (srv Server) RetryPending(ctx context.Context) error {
+	pending := srv.pending.list()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	nodes, err := kube.ListNodes(ctx, srv.GetKubeClient(), viper.GetString("kured-label"))
+	if err != nil {
+		return err
+	}
+
+	labelled := make(map[string]int, len(nodes.Items))
+	for i := range nodes.Items {
+		labelled[nodes.Items[i].Name] = i
+	}
+
+	for _, name := range pending {
+		i, ok := labelled[name]
+		if !ok {
+			srv.pending.remove(name)
+			srv.logger.Debugw("pending node lost the label", "node", name)
+
+			continue
+		}
+
+		if err := srv.EventHandler(ctx, watch.Event{Type: watch.Added, Object: &nodes.Items[i]}); err != nil {
+			srv.logger.Debugw("pending node still not silenced", "node", name, "error", err)
+		}
+	}
+
+	return nil
+}
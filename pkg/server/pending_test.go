@@ -0,0 +1,80 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/server"
+
+	"go.uber.org/zap"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPendingNodes(t *testing.T) {
+	ctx := context.Background()
+
+	viper.Set("kured-label", "silence=true")
+
+	node := func(name string, ready v1.ConditionStatus) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"silence": "true"}},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: ready}},
+			},
+		}
+	}
+
+	kcli := fake.NewSimpleClientset(node("node-1", v1.ConditionFalse), node("node-2", v1.ConditionFalse))
+	_, amc := newFakeAlertManager(t, func() models.GettableAlerts { return models.GettableAlerts{} })
+
+	state := server.NewMemoryStateStore()
+
+	srv := server.Server{
+		Client: &server.Client{
+			KubeClient: kcli,
+			AMClient:   amc,
+		},
+	}.WithLogger(ctx, zap.NewNop().Sugar()).
+		WithStateStore(ctx, state).
+		WithRemovalBuffer(ctx, 0).
+		WithPreChecks(ctx, []server.PreCheck{{Type: server.PreCheckReady}}).
+		WithPendingRetryInterval(ctx, time.Minute)
+
+	for _, name := range []string{"node-1", "node-2"} {
+		err := srv.EventHandler(ctx, watch.Event{Type: watch.Added, Object: node(name, v1.ConditionFalse)})
+		assert.ErrorIs(t, err, server.ErrNodeNotReady)
+	}
+
+	// modifications of nodes that were never pending are ignored
+	assert.NoError(t, srv.EventHandler(ctx, watch.Event{Type: watch.Modified, Object: node("node-3", v1.ConditionTrue)}))
+
+	_, ok, err := state.Get(ctx, "node-3")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// a pending node is silenced once a modification makes it pass the pre-checks
+	assert.NoError(t, srv.EventHandler(ctx, watch.Event{Type: watch.Modified, Object: node("node-1", v1.ConditionTrue)}))
+
+	_, ok, err = state.Get(ctx, "node-1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// and when it is retried after passing without an observed modification
+	_, err = kcli.CoreV1().Nodes().UpdateStatus(ctx, node("node-2", v1.ConditionTrue), metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	assert.NoError(t, srv.RetryPending(ctx))
+
+	_, ok, err = state.Get(ctx, "node-2")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/kube"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// awaitPodsReady watches the pods bound to the node until every DaemonSet pod and every
+// pod matching the configured pod selector is ready, or the pod wait timeout has passed
+func (srv Server) awaitPodsReady(ctx context.Context, node string) {
+	waitCtx, cancel := context.WithTimeout(ctx, srv.podsTimeout)
+	defer cancel()
+
+	informer := kube.NewNodePodInformer(srv.GetKubeClient(), node)
+	changed := make(chan struct{}, 1)
+
+	notify := func(interface{}) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    notify,
+		UpdateFunc: func(_, obj interface{}) { notify(obj) },
+		DeleteFunc: notify,
+	}); err != nil {
+		srv.logger.Errorw("unable to watch node pods", "node", node, "error", err)
+		return
+	}
+
+	go informer.Run(waitCtx.Done())
+
+	if !cache.WaitForCacheSync(waitCtx.Done(), informer.HasSynced) {
+		srv.podsNotReady(ctx, node, srv.unreadyPods(informer.GetStore().List()))
+		return
+	}
+
+	for {
+		unready := srv.unreadyPods(informer.GetStore().List())
+		if len(unready) == 0 {
+			return
+		}
+
+		select {
+		case <-waitCtx.Done():
+			srv.podsNotReady(ctx, node, unready)
+			return
+		case <-changed:
+		}
+	}
+}
+
+// podsNotReady reports that the pod wait timed out, unless it was cancelled
+func (srv Server) podsNotReady(ctx context.Context, node string, unready []string) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	srv.logger.Warnw("removing silences with pods not ready", "node", node, "pods", unready, "waited", srv.podsTimeout)
+	srv.eventf(node, v1.EventTypeWarning, "SilenceRemovedWithPodsNotReady",
+		"silences removed after waiting %s with pods not ready: %s", srv.podsTimeout, strings.Join(unready, ", "))
+}
+
+// unreadyPods returns the sorted namespace/name of the DaemonSet and selected pods that are not ready
+func (srv Server) unreadyPods(objs []interface{}) []string {
+	unready := []string{}
+
+	for _, obj := range objs {
+		pod := obj.(*v1.Pod)
+
+		if !isDaemonSetPod(pod) && (srv.podSelector == nil || !srv.podSelector.Matches(labels.Set(pod.Labels))) {
+			continue
+		}
+
+		// completed pods will never report ready
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+
+		if !isPodReady(pod) {
+			unready = append(unready, pod.Namespace+"/"+pod.Name)
+		}
+	}
+
+	sort.Strings(unready)
+
+	return unready
+}
+
+// parsePodSelector parses the selector of the workload pods waited on besides DaemonSet pods
+func parsePodSelector(selector string) (labels.Selector, error) {
+	if selector == "" {
+		return nil, nil
+	}
+
+	s, err := labels.Parse(selector)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidPodSelector, err)
+	}
+
+	return s, nil
+}
+
+func isDaemonSetPod(pod *v1.Pod) bool {
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "DaemonSet" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isPodReady(pod *v1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodReady {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+
+	return false
+}
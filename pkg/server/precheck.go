@@ -0,0 +1,184 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// PreCheckReady matches nodes whose Ready condition is True
+	PreCheckReady = "ready"
+
+	// PreCheckSchedulable matches nodes that are not cordoned
+	PreCheckSchedulable = "schedulable"
+
+	// PreCheckCondition matches nodes reporting the named condition with the given status, True by default
+	PreCheckCondition = "condition"
+
+	// PreCheckTaint matches nodes with a taint with the named key and, if given, effect
+	PreCheckTaint = "taint"
+
+	// PreCheckAllow only silences nodes the check matches
+	PreCheckAllow = "allow"
+
+	// PreCheckDeny does not silence nodes the check matches
+	PreCheckDeny = "deny"
+)
+
+// PreCheck is a check a node has to pass before it is silenced
+type PreCheck struct {
+	// Type is one of ready, schedulable, condition or taint
+	Type string `mapstructure:"type"`
+	// Name is the condition type or taint key
+	Name string `mapstructure:"name"`
+	// Value is the condition status or taint effect
+	Value string `mapstructure:"value"`
+	// Action is allow, the default, or deny
+	Action string `mapstructure:"action"`
+}
+
+// ParsePreCheck parses a pre-check of the form [!]type[=name[:value]], where a leading !
+// denies nodes the check matches, e.g. "ready" or "!taint=node.kubernetes.io/unreachable"
+func ParsePreCheck(s string) (PreCheck, error) {
+	c := PreCheck{Action: PreCheckAllow}
+
+	if strings.HasPrefix(s, "!") {
+		c.Action = PreCheckDeny
+		s = s[1:]
+	}
+
+	c.Type, s, _ = strings.Cut(s, "=")
+	c.Name, c.Value, _ = strings.Cut(s, ":")
+
+	return c, c.Validate()
+}
+
+// PreChecksDecodeHook returns a mapstructure decode hook that decodes strings into pre-checks
+func PreChecksDecodeHook() mapstructure.DecodeHookFuncType {
+	preCheckType := reflect.TypeOf(PreCheck{})
+
+	return func(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+		if from.Kind() != reflect.String || to != preCheckType {
+			return data, nil
+		}
+
+		return ParsePreCheck(data.(string))
+	}
+}
+
+// Validate ensures the pre-check has a known type and action, and a name where required
+func (c PreCheck) Validate() error {
+	switch c.Type {
+	case PreCheckReady, PreCheckSchedulable:
+	case PreCheckCondition, PreCheckTaint:
+		if c.Name == "" {
+			return fmt.Errorf("%w: %s requires a name", ErrInvalidPreCheck, c.Type)
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidPreCheck, c.Type)
+	}
+
+	switch c.Action {
+	case PreCheckAllow, PreCheckDeny, "":
+	default:
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidPreCheck, c.Action)
+	}
+
+	return nil
+}
+
+// ValidatePreChecks validates each of the pre-checks
+func ValidatePreChecks(checks []PreCheck) error {
+	for i, c := range checks {
+		if err := c.Validate(); err != nil {
+			return fmt.Errorf("pre-check %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (c PreCheck) String() string {
+	s := c.Type
+
+	if c.Action == PreCheckDeny {
+		s = "!" + s
+	}
+
+	if c.Name != "" {
+		s += "=" + c.Name
+	}
+
+	if c.Value != "" {
+		s += ":" + c.Value
+	}
+
+	return s
+}
+
+// matches reports whether the node matches the check, regardless of its action
+func (c PreCheck) matches(node *v1.Node) bool {
+	switch c.Type {
+	case PreCheckReady:
+		return isConditionTrue(node, v1.NodeReady)
+	case PreCheckSchedulable:
+		return !node.Spec.Unschedulable
+	case PreCheckCondition:
+		status := c.Value
+		if status == "" {
+			status = string(v1.ConditionTrue)
+		}
+
+		for _, cond := range node.Status.Conditions {
+			if string(cond.Type) == c.Name {
+				return string(cond.Status) == status
+			}
+		}
+
+		return false
+	case PreCheckTaint:
+		for _, t := range node.Spec.Taints {
+			if t.Key == c.Name && (c.Value == "" || string(t.Effect) == c.Value) {
+				return true
+			}
+		}
+
+		return false
+	default:
+		return false
+	}
+}
+
+// Check returns an error if the node does not pass the check
+func (c PreCheck) Check(node *v1.Node) error {
+	if c.matches(node) != (c.Action == PreCheckDeny) {
+		return nil
+	}
+
+	err := ErrPreCheckFailed
+
+	switch {
+	case c.Type == PreCheckReady && c.Action != PreCheckDeny:
+		err = ErrNodeNotReady
+	case c.Type == PreCheckSchedulable && c.Action != PreCheckDeny:
+		err = ErrNodeUnschedulable
+	}
+
+	return fmt.Errorf("%w: %s", err, c)
+}
+
+// preCheck returns an error for the first configured pre-check the node does not pass
+func (srv Server) preCheck(node *v1.Node) error {
+	for _, c := range srv.preChecks {
+		if err := c.Check(node); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
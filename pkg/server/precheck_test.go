@@ -0,0 +1,144 @@
+package server_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/server"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestParsePreCheck(t *testing.T) {
+	type testCase struct {
+		name           string
+		input          string
+		expected       server.PreCheck
+		expectedErrors []error
+	}
+
+	testCases := []testCase{
+		{
+			name:     "ready",
+			input:    "ready",
+			expected: server.PreCheck{Type: server.PreCheckReady, Action: server.PreCheckAllow},
+		},
+		{
+			name:     "denied taint with effect",
+			input:    "!taint=node.kubernetes.io/unreachable:NoExecute",
+			expected: server.PreCheck{Type: server.PreCheckTaint, Name: "node.kubernetes.io/unreachable", Value: "NoExecute", Action: server.PreCheckDeny},
+		},
+		{
+			name:     "condition",
+			input:    "condition=DiskPressure:False",
+			expected: server.PreCheck{Type: server.PreCheckCondition, Name: "DiskPressure", Value: "False", Action: server.PreCheckAllow},
+		},
+		{
+			name:           "condition without name",
+			input:          "condition",
+			expectedErrors: []error{server.ErrInvalidPreCheck},
+		},
+		{
+			name:           "unknown type",
+			input:          "!drained",
+			expectedErrors: []error{server.ErrInvalidPreCheck},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			check, err := server.ParsePreCheck(tc.input)
+
+			if len(tc.expectedErrors) > 0 {
+				assert.Error(t, err)
+				for _, expectedError := range tc.expectedErrors {
+					assert.ErrorIs(t, err, expectedError)
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, check)
+				assert.Equal(t, tc.input, check.String())
+			}
+		})
+	}
+}
+
+func TestPreCheck(t *testing.T) {
+	node := &v1.Node{
+		Spec: v1.NodeSpec{
+			Unschedulable: true,
+			Taints:        []v1.Taint{{Key: "node.kubernetes.io/unschedulable", Effect: v1.TaintEffectNoSchedule}},
+		},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: v1.ConditionFalse},
+				{Type: v1.NodeDiskPressure, Status: v1.ConditionFalse},
+			},
+		},
+	}
+
+	type testCase struct {
+		name           string
+		check          string
+		expectedErrors []error
+	}
+
+	testCases := []testCase{
+		{
+			name:           "ready condition present but not true",
+			check:          "ready",
+			expectedErrors: []error{server.ErrNodeNotReady},
+		},
+		{
+			name:  "not ready denied",
+			check: "!ready",
+		},
+		{
+			name:           "schedulable",
+			check:          "schedulable",
+			expectedErrors: []error{server.ErrNodeUnschedulable},
+		},
+		{
+			name:  "condition status",
+			check: "condition=DiskPressure:False",
+		},
+		{
+			name:           "condition defaults to true",
+			check:          "condition=DiskPressure",
+			expectedErrors: []error{server.ErrPreCheckFailed},
+		},
+		{
+			name:           "missing condition",
+			check:          "condition=NetworkUnavailable",
+			expectedErrors: []error{server.ErrPreCheckFailed},
+		},
+		{
+			name:           "denied taint",
+			check:          "!taint=node.kubernetes.io/unschedulable",
+			expectedErrors: []error{server.ErrPreCheckFailed},
+		},
+		{
+			name:  "denied taint with other effect",
+			check: "!taint=node.kubernetes.io/unschedulable:NoExecute",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			check, err := server.ParsePreCheck(tc.check)
+			assert.NoError(t, err)
+
+			err = check.Check(node)
+
+			if len(tc.expectedErrors) > 0 {
+				assert.Error(t, err)
+				for _, expectedError := range tc.expectedErrors {
+					assert.ErrorIs(t, err, expectedError)
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
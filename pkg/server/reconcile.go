@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/kube"
+)
+
+// ReconcileResult contains the counts of a single reconcile run
+type ReconcileResult struct {
+	Created   int
+	Scheduled int
+	Untouched int
+	Failed    int
+}
+
+// Reconcile compares the nodes carrying the kured label with the silences created by
+// kured-silencer, silencing labelled nodes that are missing silences and scheduling the
+// removal of silences of nodes that are no longer labelled
+func (srv Server) Reconcile(ctx context.Context) (ReconcileResult, error) {
+	result := ReconcileResult{}
+
+	nodes, err := kube.ListNodes(ctx, srv.GetKubeClient(), viper.GetString("kured-label"))
+	if err != nil {
+		return result, err
+	}
+
+	silenced, err := srv.nodeSilences(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	labelled := make(map[string]bool, len(nodes.Items))
+
+	// the scheduled removals report the silences they expired, never blocking as each node
+	// is scheduled at most once
+	expired := make(chan int, len(silenced))
+
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		labelled[node.Name] = true
+
+		if !srv.shard.owns(node.Name) {
+			continue
+		}
+
+		if silences, ok := silenced[node.Name]; ok {
+			// keep what the state store knows beyond the silence ids
+			state, _, err := srv.state.Get(ctx, node.Name)
+			if err != nil {
+				srv.logger.Errorw("unable to get stored node state", "node", node.Name, "error", err)
+			}
+
+			state.SilenceIDs = silenceIDs(silences)
+
+			if queries, ok := srv.restoreRemoveWhen(ctx, node.Name, silences); ok {
+				state.RemoveWhen = queries
+			}
+
+			if err := srv.state.Set(ctx, node.Name, state); err != nil {
+				srv.logger.Errorw("unable to store node state", "node", node.Name, "error", err)
+			}
+
+			result.Untouched++
+
+			continue
+		}
+
+		state, _, err := srv.state.Get(ctx, node.Name)
+		if err != nil {
+			srv.logger.Errorw("unable to get stored node state", "node", node.Name, "error", err)
+		}
+
+		// the silences of a node stuck past the maximum silence duration ended on purpose
+		if state.Stuck {
+			if len(state.SilenceIDs) > 0 {
+				state.SilenceIDs = nil
+				state.RemoveWhen = nil
+
+				if err := srv.state.Set(ctx, node.Name, state); err != nil {
+					srv.logger.Errorw("unable to store node state", "node", node.Name, "error", err)
+				}
+			}
+
+			result.Untouched++
+
+			continue
+		}
+
+		if err := srv.preCheck(node); err != nil {
+			srv.logger.Debugw("skipping node that failed pre-check", "node", node.Name, "error", err)
+			continue
+		}
+
+		if _, err := srv.silenceNode(ctx, node); err != nil {
+			srv.logger.Errorw("unable to silence node", "node", node.Name, "error", err)
+			result.Failed++
+
+			continue
+		}
+
+		result.Created++
+	}
+
+	for node, silences := range silenced {
+		if labelled[node] || !srv.shard.owns(node) {
+			continue
+		}
+
+		// the removal buffer of nodes that just lost their label is still running
+		if srv.removals.pending(node) {
+			result.Untouched++
+			continue
+		}
+
+		// orphaned silences are removed like those of a node that just lost its label
+		state, _, err := srv.state.Get(ctx, node)
+		if err != nil {
+			srv.logger.Errorw("unable to get stored node state", "node", node, "error", err)
+		}
+
+		state.SilenceIDs = silenceIDs(silences)
+
+		if queries, ok := srv.restoreRemoveWhen(ctx, node, silences); ok {
+			state.RemoveWhen = queries
+		}
+
+		if err := srv.state.Set(ctx, node, state); err != nil {
+			srv.logger.Errorw("unable to store orphaned silences", "node", node, "error", err)
+			result.Failed++
+
+			continue
+		}
+
+		if srv.scheduleRemoval(ctx, node, func(n int) { expired <- n }) {
+			result.Scheduled++
+		}
+	}
+
+	if result.Scheduled > 0 {
+		go srv.logReconciledRemovals(ctx, result.Scheduled, expired)
+	}
+
+	return result, nil
+}
+
+// expireSilences expires the given silences of a node that is no longer labelled
+func (srv Server) expireSilences(ctx context.Context, node string, ids []string) error {
+	for i, id := range ids {
+		if err := srv.deleteSilence(ctx, id); err != nil {
+			srv.forgetSilences(ctx, node, ids[:i])
+			return err
+		}
+	}
+
+	return srv.state.Delete(ctx, node)
+}
+
+// forgetSilences drops the expired silences from the stored state of the node, so a retry
+// only expires the silences that are left
+func (srv Server) forgetSilences(ctx context.Context, node string, expired []string) {
+	if len(expired) == 0 {
+		return
+	}
+
+	state, ok, err := srv.state.Get(ctx, node)
+	if err != nil || !ok {
+		return
+	}
+
+	gone := make(map[string]bool, len(expired))
+	for _, id := range expired {
+		gone[id] = true
+	}
+
+	ids := []string{}
+
+	for _, id := range state.SilenceIDs {
+		if gone[id] {
+			delete(state.RemoveWhen, id)
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	state.SilenceIDs = ids
+
+	if err := srv.state.Set(ctx, node, state); err != nil {
+		srv.logger.Errorw("unable to store node state", "node", node, "error", err)
+	}
+}
+
+// logReconciledRemovals logs the number of silences expired by the removals a reconcile
+// run scheduled once they all finished, removals stopped on step down are not waited for
+func (srv Server) logReconciledRemovals(ctx context.Context, scheduled int, expired <-chan int) {
+	total := 0
+
+	for i := 0; i < scheduled; i++ {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-expired:
+			total += n
+		}
+	}
+
+	srv.logger.Infow("reconciled silence removals complete", "nodes", scheduled, "expired", total)
+}
+
+// reconcileTicker returns a channel that fires every reconcile interval, or nil if
+// periodic reconciliation is disabled
+func (srv Server) reconcileTicker() (<-chan time.Time, func()) {
+	if srv.reconcileInterval <= 0 {
+		return nil, func() {}
+	}
+
+	t := time.NewTicker(srv.reconcileInterval)
+
+	return t.C, t.Stop
+}
+
+func (srv Server) runReconcile(ctx context.Context) {
+	result, err := srv.Reconcile(ctx)
+	if err != nil {
+		srv.logger.Errorw("reconcile failed", "error", err)
+		return
+	}
+
+	srv.logger.Infow("reconcile complete",
+		"created", result.Created,
+		"scheduled", result.Scheduled,
+		"untouched", result.Untouched,
+		"failed", result.Failed,
+	)
+}
@@ -0,0 +1,140 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/alertmanager"
+	"github.com/tylerauerbeck/kured-silencer/pkg/server"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+
+	endpoint, err := AMContainer.Endpoint(ctx, "")
+	if err != nil {
+		t.Error(err)
+	}
+
+	u, err := url.Parse(fmt.Sprintf("http://%s", endpoint))
+	if err != nil {
+		t.Error(err)
+	}
+
+	viper.Set("kured-label", "silence=true")
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "node-reconcile",
+			Labels: map[string]string{"silence": "true"},
+		},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{
+				{
+					Type:   v1.NodeReady,
+					Status: v1.ConditionTrue,
+				},
+			},
+		},
+	}
+
+	kcli := fake.NewSimpleClientset()
+	state := server.NewMemoryStateStore()
+
+	srv := server.Server{
+		Client: &server.Client{
+			KubeClient: kcli,
+			AMClient:   alertmanager.NewSilencerClient(ctx, u),
+		},
+	}.WithLogger(ctx, zap.NewNop().Sugar()).WithSilenceDuration(ctx, 5*time.Minute).WithStateStore(ctx, state)
+
+	// expire anything left behind by other tests before any node is labelled
+	_, err = srv.Reconcile(ctx)
+	assert.NoError(t, err)
+
+	_, err = kcli.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	result, err := srv.Reconcile(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, server.ReconcileResult{Created: 1}, result)
+
+	_, ok, err := state.Get(ctx, node.Name)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	result, err = srv.Reconcile(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, server.ReconcileResult{Untouched: 1}, result)
+
+	assert.NoError(t, kcli.CoreV1().Nodes().Delete(ctx, node.Name, metav1.DeleteOptions{}))
+
+	result, err = srv.Reconcile(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, server.ReconcileResult{Scheduled: 1}, result)
+
+	assert.Eventually(t, func() bool {
+		_, ok, err := state.Get(ctx, node.Name)
+		return err == nil && !ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestReconcileSchedulesOrphanedRemovals(t *testing.T) {
+	ctx := context.Background()
+
+	viper.Set("kured-label", "silence=true")
+
+	// node-1 lost the label while no replica was running
+	fam, amc := newFakeAlertManager(t, func() models.GettableAlerts { return models.GettableAlerts{} })
+	fam.nodeSilence("id-1", "node-1")
+
+	state := server.NewMemoryStateStore()
+	core, logs := observer.New(zap.InfoLevel)
+
+	srv := server.Server{
+		Client: &server.Client{
+			KubeClient: fake.NewSimpleClientset(),
+			AMClient:   amc,
+		},
+	}.WithLogger(ctx, zap.New(core).Sugar()).
+		WithStateStore(ctx, state).
+		WithRemovalBuffer(ctx, 50*time.Millisecond)
+
+	// the orphaned silences wait for the removal buffer like any other removal
+	result, err := srv.Reconcile(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, server.ReconcileResult{Scheduled: 1}, result)
+	assert.Empty(t, fam.deletedSilences())
+
+	result, err = srv.Reconcile(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, server.ReconcileResult{Untouched: 1}, result)
+
+	assert.Eventually(t, func() bool {
+		_, ok, err := state.Get(ctx, "node-1")
+		return err == nil && !ok
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, []string{"id-1"}, fam.deletedSilences())
+
+	// the silences expired by the scheduled removals are logged once they finished
+	assert.Eventually(t, func() bool {
+		return logs.FilterMessage("reconciled silence removals complete").Len() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, int64(1), logs.FilterMessage("reconciled silence removals complete").All()[0].ContextMap()["expired"])
+}
@@ -0,0 +1,409 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/alertmanager"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// RemovalStrategyBuffer removes silences once the removal buffer has passed
+	RemovalStrategyBuffer = "buffer"
+
+	// RemovalStrategyAlertsResolved removes silences once the removal buffer has passed and
+	// none of the alerts they silence are firing anymore, bounded by the maximum removal wait
+	RemovalStrategyAlertsResolved = "alerts-resolved"
+)
+
+var (
+	defaultPollInterval = 30 * time.Second
+)
+
+// removalScheduler tracks a delayed silence removal per node. A removal stays pending
+// from the moment it is scheduled until its function returns, and cancelling it also
+// cancels the context the function runs with. A nil scheduler does not track removals,
+// so they can not be cancelled.
+type removalScheduler struct {
+	mu       sync.Mutex
+	removals map[string]*removal
+}
+
+type removal struct {
+	timer    *time.Timer
+	cancel   context.CancelFunc
+	finished chan struct{}
+	until    time.Time
+}
+
+func newRemovalScheduler() *removalScheduler {
+	return &removalScheduler{
+		removals: make(map[string]*removal),
+	}
+}
+
+// schedule runs fn for the node once the delay has passed, unless a removal is already
+// pending for the node. The node's silences are kept until fn returns, but no longer than
+// until. It reports whether a new removal was scheduled.
+func (r *removalScheduler) schedule(ctx context.Context, node string, delay time.Duration, until time.Time, fn func(context.Context)) bool {
+	if r == nil {
+		time.AfterFunc(delay, func() { fn(ctx) })
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.removals[node]; ok {
+		return false
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	rm := &removal{cancel: cancel, finished: make(chan struct{}), until: until}
+
+	rm.timer = time.AfterFunc(delay, func() {
+		defer close(rm.finished)
+		defer r.done(node, rm)
+
+		fn(ctx)
+	})
+
+	r.removals[node] = rm
+
+	return true
+}
+
+// done forgets the removal once its function has returned
+func (r *removalScheduler) done(node string, rm *removal) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.removals[node] == rm {
+		delete(r.removals, node)
+	}
+
+	rm.cancel()
+}
+
+// cancel stops the pending removal of the node, reporting whether one was pending
+func (r *removalScheduler) cancel(node string) bool {
+	if r == nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rm, ok := r.removals[node]
+	if !ok {
+		return false
+	}
+
+	delete(r.removals, node)
+
+	rm.timer.Stop()
+	rm.cancel()
+
+	return true
+}
+
+// stop cancels the removals whose delay has not passed yet and waits for the running ones
+// to return until ctx is done, reporting whether they all returned
+func (r *removalScheduler) stop(ctx context.Context) bool {
+	if r == nil {
+		return true
+	}
+
+	r.mu.Lock()
+
+	running := []chan struct{}{}
+
+	for node, rm := range r.removals {
+		if !rm.timer.Stop() {
+			running = append(running, rm.finished)
+			continue
+		}
+
+		delete(r.removals, node)
+		rm.cancel()
+	}
+
+	r.mu.Unlock()
+
+	for _, finished := range running {
+		select {
+		case <-finished:
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	return true
+}
+
+// pending reports whether a removal is pending for the node
+func (r *removalScheduler) pending(node string) bool {
+	if r == nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.removals[node]
+
+	return ok
+}
+
+// keepUntil returns how long the silences of a node with a pending removal are kept at most
+func (r *removalScheduler) keepUntil(node string) (time.Time, bool) {
+	if r == nil {
+		return time.Time{}, false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rm, ok := r.removals[node]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return rm.until, true
+}
+
+// removalWait returns how long a removal may wait for its gates once the buffer has passed
+func (srv Server) removalWait() time.Duration {
+	wait := srv.removalMaxWait
+
+	if srv.waitForPods {
+		wait += srv.podsTimeout
+	}
+
+	return wait
+}
+
+// removeSilences expires the silences of a node that lost its label once the configured
+// removal strategy allows it, returning the number of silences it expired. It gives up
+// without expiring anything if ctx is cancelled, which happens when the label is re-added
+// while waiting.
+func (srv Server) removeSilences(ctx context.Context, node string) (int, error) {
+	state, ok, err := srv.state.Get(ctx, node)
+	if err != nil {
+		return 0, err
+	}
+
+	if !ok {
+		return 0, ErrMissingNode
+	}
+
+	srv.verifyReboot(ctx, node, state.BootID)
+
+	if srv.waitForPods {
+		srv.awaitPodsReady(ctx, node)
+	}
+
+	deadline := time.Now().Add(srv.removalMaxWait)
+
+	if srv.removalStrategy == RemovalStrategyAlertsResolved {
+		srv.awaitAlertsResolved(ctx, node, state.SilenceIDs, deadline)
+	}
+
+	// each silence waits for its own removeWhen query, so one slow condition does not
+	// hold back the silences that are ready to be removed
+	var wg sync.WaitGroup
+
+	errs := make([]error, len(state.SilenceIDs))
+
+	for i, id := range state.SilenceIDs {
+		wg.Add(1)
+
+		go func(i int, id string) {
+			defer wg.Done()
+
+			if query := state.RemoveWhen[id]; query != "" {
+				srv.awaitQueryEmpty(ctx, node, query, deadline)
+			}
+
+			if errs[i] = ctx.Err(); errs[i] != nil {
+				return
+			}
+
+			errs[i] = srv.deleteSilence(ctx, id)
+		}(i, id)
+	}
+
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		expired := []string{}
+
+		for i, id := range state.SilenceIDs {
+			if errs[i] == nil {
+				expired = append(expired, id)
+			}
+		}
+
+		srv.forgetSilences(ctx, node, expired)
+
+		return len(expired), err
+	}
+
+	return len(state.SilenceIDs), srv.state.Delete(ctx, node)
+}
+
+// verifyReboot compares the boot id of the node with the one recorded when it was silenced.
+// A changed boot id on a ready node proves the reboot happened, an unchanged one means the
+// label was removed without a reboot.
+func (srv Server) verifyReboot(ctx context.Context, node, bootID string) {
+	// silences restored from alertmanager do not know the boot id
+	if bootID == "" {
+		return
+	}
+
+	n, err := srv.GetKubeClient().CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			srv.logger.Warnw("unable to get node to verify reboot", "node", node, "error", err)
+		}
+
+		return
+	}
+
+	current := n.Status.NodeInfo.BootID
+
+	switch {
+	case current == bootID:
+		srv.logger.Warnw("node unlabelled without rebooting", "node", node, "bootID", bootID)
+		srv.eventf(node, v1.EventTypeWarning, "UnlabelledWithoutReboot",
+			"node was unlabelled without rebooting, boot id is still %s", bootID)
+	case isConditionTrue(n, v1.NodeReady):
+		srv.logger.Infow("node rebooted", "node", node, "previousBootID", bootID, "bootID", current)
+	default:
+		srv.logger.Infow("node rebooted but is not ready", "node", node, "previousBootID", bootID, "bootID", current)
+	}
+}
+
+// isConditionTrue returns whether the node reports the condition with status true
+func isConditionTrue(node *v1.Node, condition v1.NodeConditionType) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == condition {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// awaitQueryEmpty polls prometheus until the query returns an empty vector, or the
+// deadline has passed
+func (srv Server) awaitQueryEmpty(ctx context.Context, node, query string, deadline time.Time) {
+	// queries stored before the prometheus endpoint was removed from the configuration
+	if srv.Client.PromClient == nil {
+		srv.logger.Warnw("not waiting for removal query without a prometheus endpoint", "node", node, "query", query)
+		return
+	}
+
+	ticker := time.NewTicker(srv.pollInterval())
+	defer ticker.Stop()
+
+	for {
+		samples, err := srv.Client.PromClient.Query(ctx, query)
+
+		switch {
+		case err != nil:
+			srv.logger.Warnw("unable to evaluate removal query", "node", node, "query", query, "error", err)
+		case samples == 0:
+			return
+		default:
+			srv.logger.Debugw("waiting for removal query to return no samples", "node", node, "query", query, "samples", samples)
+		}
+
+		if time.Now().After(deadline) {
+			srv.logger.Warnw("removing silence with removal query still returning samples", "node", node, "query", query)
+			srv.eventf(node, v1.EventTypeWarning, "SilenceRemovedWithConditionUnmet",
+				"silence removed after waiting %s with removal query still returning samples: %s", srv.removalMaxWait, query)
+
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// awaitAlertsResolved polls alertmanager until none of the alerts silenced by the given
+// silences are firing anymore, or the deadline has passed
+func (srv Server) awaitAlertsResolved(ctx context.Context, node string, ids []string, deadline time.Time) {
+	ticker := time.NewTicker(srv.pollInterval())
+	defer ticker.Stop()
+
+	for {
+		alerts, err := alertmanager.SilencedAlerts(ctx, srv.Client.AMClient, ids)
+
+		switch {
+		case err != nil:
+			srv.logger.Warnw("unable to get silenced alerts", "node", node, "error", err)
+		case len(alerts) == 0:
+			return
+		default:
+			srv.logger.Debugw("waiting for silenced alerts to resolve", "node", node, "alerts", len(alerts))
+		}
+
+		if time.Now().After(deadline) {
+			names := alertNames(alerts)
+
+			srv.logger.Warnw("removing silences with alerts still firing", "node", node, "alerts", names, "waited", srv.removalMaxWait)
+			srv.eventf(node, v1.EventTypeWarning, "SilenceRemovedWithFiringAlerts",
+				"silences removed after waiting %s with alerts still firing: %s", srv.removalMaxWait, strings.Join(names, ", "))
+
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollInterval returns the interval removal conditions are checked at
+func (srv Server) pollInterval() time.Duration {
+	if srv.removalPollInterval <= 0 {
+		return defaultPollInterval
+	}
+
+	return srv.removalPollInterval
+}
+
+// alertNames returns the sorted, unique alertname labels of the given alerts
+func alertNames(alerts []*models.GettableAlert) []string {
+	seen := make(map[string]bool)
+	names := []string{}
+
+	for _, a := range alerts {
+		name := a.Labels["alertname"]
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
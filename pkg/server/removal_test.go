@@ -0,0 +1,406 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/prometheus"
+	"github.com/tylerauerbeck/kured-silencer/pkg/server"
+
+	"go.uber.org/zap"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestDelayedRemoval(t *testing.T) {
+	ctx := context.Background()
+	state := server.NewMemoryStateStore()
+	buffer := 100 * time.Millisecond
+
+	srv := server.Server{
+		Client: &server.Client{
+			KubeClient: fake.NewSimpleClientset(),
+		},
+	}.WithLogger(ctx, zap.NewNop().Sugar()).WithStateStore(ctx, state).WithRemovalBuffer(ctx, buffer)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	other := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}}
+
+	assert.NoError(t, state.Set(ctx, node.Name, server.NodeState{}))
+	assert.NoError(t, state.Set(ctx, other.Name, server.NodeState{}))
+
+	// removals do not block the handler
+	start := time.Now()
+	assert.NoError(t, srv.EventHandler(ctx, watch.Event{Type: watch.Deleted, Object: node}))
+	assert.NoError(t, srv.EventHandler(ctx, watch.Event{Type: watch.Deleted, Object: other}))
+	assert.Less(t, time.Since(start), buffer)
+
+	// the label reappearing cancels the removal
+	assert.NoError(t, srv.EventHandler(ctx, watch.Event{Type: watch.Added, Object: other}))
+
+	assert.Eventually(t, func() bool {
+		_, ok, _ := state.Get(ctx, node.Name)
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+
+	time.Sleep(buffer)
+
+	_, ok, err := state.Get(ctx, other.Name)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// nodes that were never silenced have nothing to remove
+	err = srv.EventHandler(ctx, watch.Event{Type: watch.Deleted, Object: &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-3"}}})
+	assert.ErrorIs(t, err, server.ErrMissingNode)
+}
+
+func TestAlertsResolvedRemoval(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		mu     sync.Mutex
+		firing = models.GettableAlerts{firingAlert("NodeDown", "id-1"), firingAlert("Unrelated", "id-other")}
+	)
+
+	fam, amc := newFakeAlertManager(t, func() models.GettableAlerts {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return firing
+	})
+
+	state := server.NewMemoryStateStore()
+	recorder := record.NewFakeRecorder(10)
+
+	srv := server.Server{
+		Client: &server.Client{
+			KubeClient: fake.NewSimpleClientset(),
+			AMClient:   amc,
+		},
+	}.WithLogger(ctx, zap.NewNop().Sugar()).
+		WithStateStore(ctx, state).
+		WithEventRecorder(ctx, recorder).
+		WithRemovalBuffer(ctx, 0).
+		WithRemovalStrategy(ctx, server.RemovalStrategyAlertsResolved, time.Minute, 10*time.Millisecond)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	assert.NoError(t, state.Set(ctx, node.Name, server.NodeState{SilenceIDs: []string{"id-1"}}))
+	assert.NoError(t, srv.EventHandler(ctx, watch.Event{Type: watch.Deleted, Object: node}))
+
+	// the silence is kept while an alert it silences is still firing
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, fam.deletedSilences())
+
+	mu.Lock()
+	firing = models.GettableAlerts{firingAlert("Unrelated", "id-other")}
+	mu.Unlock()
+
+	assert.Eventually(t, func() bool {
+		return len(fam.deletedSilences()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, []string{"id-1"}, fam.deletedSilences())
+	assert.Empty(t, recorder.Events)
+
+	// after the maximum wait the silence is removed anyway and an event lists the firing alerts
+	srv = srv.WithRemovalStrategy(ctx, server.RemovalStrategyAlertsResolved, 50*time.Millisecond, 10*time.Millisecond)
+
+	mu.Lock()
+	firing = models.GettableAlerts{firingAlert("NodeDown", "id-2"), firingAlert("KubeletDown", "id-2")}
+	mu.Unlock()
+
+	assert.NoError(t, state.Set(ctx, node.Name, server.NodeState{SilenceIDs: []string{"id-2"}}))
+	assert.NoError(t, srv.EventHandler(ctx, watch.Event{Type: watch.Deleted, Object: node}))
+
+	assert.Eventually(t, func() bool {
+		return len(fam.deletedSilences()) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	event := <-recorder.Events
+	assert.Contains(t, event, "SilenceRemovedWithFiringAlerts")
+	assert.Contains(t, event, "KubeletDown, NodeDown")
+}
+
+func TestRemoveWhenRemoval(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		mu    sync.Mutex
+		ready bool
+	)
+
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		result := `[{"metric":{"node":"node-1"},"value":[1,"0"]}]`
+		if ready || r.URL.Query().Get("query") != `up{node="node-1"} == 0` {
+			result = `[]`
+		}
+
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":` + result + `}}`))
+	}))
+	defer prom.Close()
+
+	u, err := url.Parse(prom.URL)
+	assert.NoError(t, err)
+
+	fam, amc := newFakeAlertManager(t, func() models.GettableAlerts { return models.GettableAlerts{} })
+
+	state := server.NewMemoryStateStore()
+
+	srv := server.Server{
+		Client: &server.Client{
+			KubeClient: fake.NewSimpleClientset(),
+			AMClient:   amc,
+			PromClient: prometheus.NewClient(ctx, u),
+		},
+	}.WithLogger(ctx, zap.NewNop().Sugar()).
+		WithStateStore(ctx, state).
+		WithRemovalBuffer(ctx, 0).
+		WithRemovalStrategy(ctx, server.RemovalStrategyBuffer, time.Minute, 10*time.Millisecond)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	assert.NoError(t, state.Set(ctx, node.Name, server.NodeState{
+		SilenceIDs: []string{"id-1", "id-2", "id-3"},
+		RemoveWhen: map[string]string{
+			"id-2": `up{node="node-1"} == 0`,
+			"id-3": `up{node="node-1", job="ok"} == 0`,
+		},
+	}))
+
+	assert.NoError(t, srv.EventHandler(ctx, watch.Event{Type: watch.Deleted, Object: node}))
+
+	// silences without an unmet condition are removed right away
+	assert.Eventually(t, func() bool {
+		return len(fam.deletedSilences()) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	assert.ElementsMatch(t, []string{"id-1", "id-3"}, fam.deletedSilences())
+
+	_, ok, err := state.Get(ctx, node.Name)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	mu.Lock()
+	ready = true
+	mu.Unlock()
+
+	assert.Eventually(t, func() bool {
+		_, ok, _ := state.Get(ctx, node.Name)
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+
+	assert.ElementsMatch(t, []string{"id-1", "id-2", "id-3"}, fam.deletedSilences())
+}
+
+func TestRemoveWhenWithoutPrometheus(t *testing.T) {
+	ctx := context.Background()
+
+	fam, amc := newFakeAlertManager(t, func() models.GettableAlerts { return models.GettableAlerts{} })
+	fam.nodeSilence("id-1", "node-1")
+
+	// the query was stored before removeWhen and the prometheus endpoint were configured away
+	state := server.NewMemoryStateStore()
+	assert.NoError(t, state.Set(ctx, "node-1", server.NodeState{
+		SilenceIDs: []string{"id-1"},
+		RemoveWhen: map[string]string{"id-1": `up{node="node-1"} == 0`},
+	}))
+
+	newServer := func(buffer time.Duration) *server.Server {
+		return server.Server{
+			Client: &server.Client{
+				KubeClient: fake.NewSimpleClientset(),
+				AMClient:   amc,
+			},
+		}.WithLogger(ctx, zap.NewNop().Sugar()).
+			WithStateStore(ctx, state).
+			WithRemovalBuffer(ctx, buffer)
+	}
+
+	srv := newServer(time.Hour)
+
+	// reconciling drops the queries of definitions that are no longer configured
+	_, err := srv.Reconcile(ctx)
+	assert.NoError(t, err)
+
+	ns, ok, err := state.Get(ctx, "node-1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Empty(t, ns.RemoveWhen)
+
+	// queries that are still stored are not waited for without a prometheus endpoint
+	assert.NoError(t, state.Set(ctx, "node-1", server.NodeState{
+		SilenceIDs: []string{"id-1"},
+		RemoveWhen: map[string]string{"id-1": `up{node="node-1"} == 0`},
+	}))
+
+	srv = newServer(0)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	assert.NoError(t, srv.EventHandler(ctx, watch.Event{Type: watch.Deleted, Object: node}))
+
+	assert.Eventually(t, func() bool {
+		_, ok, _ := state.Get(ctx, node.Name)
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, []string{"id-1"}, fam.deletedSilences())
+}
+
+func TestPodsReadyRemoval(t *testing.T) {
+	ctx := context.Background()
+
+	pod := func(name string, ready bool, owner string, labels map[string]string) *v1.Pod {
+		status := v1.ConditionFalse
+		if ready {
+			status = v1.ConditionTrue
+		}
+
+		p := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+			Spec:       v1.PodSpec{NodeName: "node-1"},
+			Status: v1.PodStatus{
+				Phase:      v1.PodRunning,
+				Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: status}},
+			},
+		}
+
+		if owner != "" {
+			p.OwnerReferences = []metav1.OwnerReference{{Kind: owner, Name: owner, APIVersion: "apps/v1"}}
+		}
+
+		return p
+	}
+
+	kcli := fake.NewSimpleClientset(
+		pod("node-exporter", false, "DaemonSet", nil),
+		pod("ingress", false, "ReplicaSet", map[string]string{"app": "ingress"}),
+		pod("unrelated", false, "ReplicaSet", nil),
+	)
+
+	selector, err := labels.Parse("app=ingress")
+	assert.NoError(t, err)
+
+	fam, amc := newFakeAlertManager(t, func() models.GettableAlerts { return models.GettableAlerts{} })
+
+	state := server.NewMemoryStateStore()
+	recorder := record.NewFakeRecorder(10)
+
+	srv := server.Server{
+		Client: &server.Client{
+			KubeClient: kcli,
+			AMClient:   amc,
+		},
+	}.WithLogger(ctx, zap.NewNop().Sugar()).
+		WithStateStore(ctx, state).
+		WithEventRecorder(ctx, recorder).
+		WithRemovalBuffer(ctx, 0).
+		WithPodsReadyGate(ctx, selector, time.Minute)
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	assert.NoError(t, state.Set(ctx, node.Name, server.NodeState{SilenceIDs: []string{"id-1"}}))
+	assert.NoError(t, srv.EventHandler(ctx, watch.Event{Type: watch.Deleted, Object: node}))
+
+	// the silence is kept while daemonset or selected pods are not ready
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, fam.deletedSilences())
+
+	_, err = kcli.CoreV1().Pods("default").UpdateStatus(ctx, pod("node-exporter", true, "DaemonSet", nil), metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, fam.deletedSilences())
+
+	_, err = kcli.CoreV1().Pods("default").UpdateStatus(ctx, pod("ingress", true, "ReplicaSet", map[string]string{"app": "ingress"}), metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return len(fam.deletedSilences()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Empty(t, recorder.Events)
+
+	// after the timeout the silence is removed anyway and an event lists the pods not ready
+	srv = srv.WithPodsReadyGate(ctx, nil, 300*time.Millisecond)
+
+	_, err = kcli.CoreV1().Pods("default").UpdateStatus(ctx, pod("node-exporter", false, "DaemonSet", nil), metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	assert.NoError(t, state.Set(ctx, node.Name, server.NodeState{SilenceIDs: []string{"id-2"}}))
+	assert.NoError(t, srv.EventHandler(ctx, watch.Event{Type: watch.Deleted, Object: node}))
+
+	assert.Eventually(t, func() bool {
+		return len(fam.deletedSilences()) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	event := <-recorder.Events
+	assert.Contains(t, event, "SilenceRemovedWithPodsNotReady")
+	assert.Contains(t, event, "default/node-exporter")
+}
+
+func TestRebootVerification(t *testing.T) {
+	ctx := context.Background()
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: v1.NodeStatus{
+			NodeInfo:   v1.NodeSystemInfo{BootID: "boot-1"},
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+		},
+	}
+
+	kcli := fake.NewSimpleClientset(node)
+	state := server.NewMemoryStateStore()
+	recorder := record.NewFakeRecorder(10)
+
+	srv := server.Server{
+		Client: &server.Client{
+			KubeClient: kcli,
+		},
+	}.WithLogger(ctx, zap.NewNop().Sugar()).
+		WithStateStore(ctx, state).
+		WithEventRecorder(ctx, recorder).
+		WithRemovalBuffer(ctx, 0)
+
+	// the node was unlabelled without a new boot id
+	assert.NoError(t, state.Set(ctx, node.Name, server.NodeState{BootID: "boot-1"}))
+	assert.NoError(t, srv.EventHandler(ctx, watch.Event{Type: watch.Deleted, Object: node}))
+
+	assert.Eventually(t, func() bool {
+		_, ok, _ := state.Get(ctx, node.Name)
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+
+	event := <-recorder.Events
+	assert.Contains(t, event, "UnlabelledWithoutReboot")
+	assert.Contains(t, event, "boot-1")
+
+	// the node rebooted
+	node.Status.NodeInfo.BootID = "boot-2"
+	_, err := kcli.CoreV1().Nodes().UpdateStatus(ctx, node, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	assert.NoError(t, state.Set(ctx, node.Name, server.NodeState{BootID: "boot-1"}))
+	assert.NoError(t, srv.EventHandler(ctx, watch.Event{Type: watch.Deleted, Object: node}))
+
+	assert.Eventually(t, func() bool {
+		_, ok, _ := state.Get(ctx, node.Name)
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Empty(t, recorder.Events)
+}
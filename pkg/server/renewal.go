@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+var (
+	minRenewalInterval = 10 * time.Second
+)
+
+// renewalTicker returns a channel that fires every half silence duration, but no more
+// often than minRenewalInterval, so silences are extended well before they end, or nil
+// if renewal is disabled
+func (srv Server) renewalTicker() (<-chan time.Time, func()) {
+	if srv.silenceMaxDuration <= 0 || srv.silenceDuration <= 0 {
+		return nil, func() {}
+	}
+
+	interval := srv.silenceDuration / 2
+	if interval < minRenewalInterval {
+		interval = minRenewalInterval
+	}
+
+	t := time.NewTicker(interval)
+
+	return t.C, t.Stop
+}
+
+// RenewSilences extends the silences of nodes that still carry the kured label by the
+// silence duration, but never past the maximum silence duration since the node was
+// silenced. Nodes that reached the maximum are reported as stuck once and no longer renewed.
+// The silences of nodes with a pending removal are extended until the removal expires them,
+// but never past the longest the removal can wait for its gates.
+func (srv Server) RenewSilences(ctx context.Context) error {
+	nodes, err := srv.state.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+
+	for node, state := range nodes {
+		if !srv.shard.owns(node) {
+			continue
+		}
+
+		renew := srv.renewNode
+
+		// the label was removed, the silences are kept while the removal waits
+		if until, ok := srv.removals.keepUntil(node); ok {
+			renew = func(ctx context.Context, node string, state NodeState) error {
+				return srv.renewRemoval(ctx, node, state, until)
+			}
+		}
+
+		if err := renew(ctx, node, state); err != nil {
+			srv.logger.Errorw("unable to renew silences", "node", node, "error", err)
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (srv Server) renewNode(ctx context.Context, node string, state NodeState) error {
+	now := time.Now()
+
+	// silences restored from alertmanager do not know when they were created
+	if state.SilencedAt.IsZero() {
+		state.SilencedAt = now
+	}
+
+	limit := state.SilencedAt.Add(srv.silenceMaxDuration)
+
+	if !now.Before(limit) {
+		if !state.Stuck {
+			srv.logger.Warnw("node still labelled after maximum silence duration, no longer renewing silences",
+				"node", node, "silencedAt", state.SilencedAt, "maxDuration", srv.silenceMaxDuration)
+			srv.eventf(node, v1.EventTypeWarning, "RebootStuck",
+				"node still labelled %s after it was silenced, silences are no longer renewed", srv.silenceMaxDuration)
+
+			state.Stuck = true
+
+			return srv.state.Set(ctx, node, state)
+		}
+
+		return nil
+	}
+
+	endsAt := now.Add(srv.silenceDuration)
+	if endsAt.After(limit) {
+		endsAt = limit
+	}
+
+	if err := srv.extendNode(ctx, node, &state, endsAt); err != nil {
+		return err
+	}
+
+	return srv.state.Set(ctx, node, state)
+}
+
+// renewRemoval extends the silences of a node with a pending removal, but never past until.
+// The state is only written when alertmanager replaced a silence, so a removal that just
+// finished does not have its state brought back.
+func (srv Server) renewRemoval(ctx context.Context, node string, state NodeState, until time.Time) error {
+	now := time.Now()
+
+	if !now.Before(until) {
+		return nil
+	}
+
+	endsAt := now.Add(srv.silenceDuration)
+	if endsAt.After(until) {
+		endsAt = until
+	}
+
+	ids := state.SilenceIDs
+
+	if err := srv.extendNode(ctx, node, &state, endsAt); err != nil {
+		return err
+	}
+
+	if sameIDs(ids, state.SilenceIDs) {
+		return nil
+	}
+
+	return srv.state.Set(ctx, node, state)
+}
+
+// extendNode extends the silences of the node until endsAt, updating the state with the
+// ids of silences alertmanager replaced
+func (srv Server) extendNode(ctx context.Context, node string, state *NodeState, endsAt time.Time) error {
+	ids := make([]string, 0, len(state.SilenceIDs))
+	removeWhen := make(map[string]string, len(state.RemoveWhen))
+
+	for _, id := range state.SilenceIDs {
+		renewed, err := srv.extendSilence(ctx, id, endsAt)
+		if err != nil {
+			return err
+		}
+
+		ids = append(ids, renewed)
+
+		if query, ok := state.RemoveWhen[id]; ok {
+			removeWhen[renewed] = query
+		}
+	}
+
+	state.SilenceIDs = ids
+
+	if len(removeWhen) > 0 {
+		state.RemoveWhen = removeWhen
+	}
+
+	srv.logger.Debugw("renewed silences", "node", node, "silences", ids, "endsAt", endsAt)
+
+	return nil
+}
+
+func sameIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,159 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/alertmanager"
+	"github.com/tylerauerbeck/kured-silencer/pkg/server"
+
+	"go.uber.org/zap"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRenewSilences(t *testing.T) {
+	ctx := context.Background()
+
+	fam, amc := newFakeAlertManager(t, func() models.GettableAlerts { return models.GettableAlerts{} })
+
+	state := server.NewMemoryStateStore()
+	recorder := record.NewFakeRecorder(10)
+
+	srv := server.Server{
+		Client: &server.Client{
+			KubeClient: fake.NewSimpleClientset(),
+			AMClient:   amc,
+		},
+	}.WithLogger(ctx, zap.NewNop().Sugar()).
+		WithStateStore(ctx, state).
+		WithEventRecorder(ctx, recorder).
+		WithRemovalBuffer(ctx, time.Minute).
+		WithSilenceDuration(ctx, 10*time.Minute).
+		WithSilenceMaxDuration(ctx, time.Hour)
+
+	now := time.Now()
+
+	assert.NoError(t, state.Set(ctx, "node-1", server.NodeState{SilenceIDs: []string{"id-1"}, SilencedAt: now}))
+	assert.NoError(t, state.Set(ctx, "node-2", server.NodeState{SilenceIDs: []string{"id-2"}, SilencedAt: now.Add(-55 * time.Minute)}))
+	assert.NoError(t, state.Set(ctx, "node-3", server.NodeState{SilenceIDs: []string{"id-3"}, SilencedAt: now.Add(-2 * time.Hour)}))
+
+	assert.NoError(t, srv.RenewSilences(ctx))
+
+	// silences are extended by the silence duration, but not past the maximum
+	assert.WithinDuration(t, now.Add(10*time.Minute), fam.endsAt("id-1"), time.Second)
+	assert.WithinDuration(t, now.Add(5*time.Minute), fam.endsAt("id-2"), time.Second)
+
+	// nodes past the maximum are reported as stuck once and no longer renewed
+	assert.WithinDuration(t, now, fam.endsAt("id-3"), time.Second)
+
+	ns, ok, err := state.Get(ctx, "node-3")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, ns.Stuck)
+
+	assert.NoError(t, srv.RenewSilences(ctx))
+	assert.Len(t, recorder.Events, 1)
+
+	event := <-recorder.Events
+	assert.Contains(t, event, "RebootStuck")
+}
+
+func TestRenewSilencesWhileRemovalWaits(t *testing.T) {
+	ctx := context.Background()
+
+	fam, amc := newFakeAlertManager(t, func() models.GettableAlerts {
+		return models.GettableAlerts{firingAlert("NodeDown", "id-1")}
+	})
+
+	state := server.NewMemoryStateStore()
+
+	srv := server.Server{
+		Client: &server.Client{
+			KubeClient: fake.NewSimpleClientset(),
+			AMClient:   amc,
+		},
+	}.WithLogger(ctx, zap.NewNop().Sugar()).
+		WithStateStore(ctx, state).
+		WithRemovalBuffer(ctx, 0).
+		WithRemovalStrategy(ctx, server.RemovalStrategyAlertsResolved, 5*time.Minute, 10*time.Millisecond).
+		WithSilenceDuration(ctx, 10*time.Minute).
+		WithSilenceMaxDuration(ctx, time.Hour)
+
+	now := time.Now()
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	assert.NoError(t, state.Set(ctx, node.Name, server.NodeState{SilenceIDs: []string{"id-1"}, SilencedAt: now}))
+	assert.NoError(t, srv.EventHandler(ctx, watch.Event{Type: watch.Deleted, Object: node}))
+
+	// the removal waits for the firing alert, its silence is extended up to the maximum wait
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, srv.RenewSilences(ctx))
+
+	assert.WithinDuration(t, now.Add(5*time.Minute), fam.endsAt("id-1"), time.Second)
+	assert.Empty(t, fam.deletedSilences())
+}
+
+func TestStuckNodeIsNotSilencedAgain(t *testing.T) {
+	viper.Set("kured-label", "silence=true")
+
+	ctx := context.Background()
+
+	_, amc := newFakeAlertManager(t, func() models.GettableAlerts { return models.GettableAlerts{} })
+
+	kcli := fake.NewSimpleClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"silence": "true"}},
+	})
+	state := server.NewMemoryStateStore()
+
+	srv := server.Server{
+		Client: &server.Client{
+			KubeClient: kcli,
+			AMClient:   amc,
+		},
+	}.WithLogger(ctx, zap.NewNop().Sugar()).
+		WithStateStore(ctx, state).
+		WithSilenceDuration(ctx, 10*time.Millisecond).
+		WithSilenceMaxDuration(ctx, 50*time.Millisecond)
+
+	result, err := srv.Reconcile(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, server.ReconcileResult{Created: 1}, result)
+
+	// the node is still labelled after the maximum silence duration
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(t, srv.RenewSilences(ctx))
+
+	ns, ok, err := state.Get(ctx, "node-1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, ns.Stuck)
+
+	// its silences end in alertmanager, they are not created again
+	for _, id := range ns.SilenceIDs {
+		assert.NoError(t, alertmanager.DeleteSilence(ctx, amc, id))
+	}
+
+	result, err = srv.Reconcile(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, server.ReconcileResult{Untouched: 1}, result)
+
+	ns, ok, err = state.Get(ctx, "node-1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, ns.Stuck)
+	assert.Empty(t, ns.SilenceIDs)
+
+	result, err = srv.Reconcile(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, server.ReconcileResult{Untouched: 1}, result)
+}
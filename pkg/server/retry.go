@@ -0,0 +1,721 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/alertmanager"
+	"github.com/tylerauerbeck/kured-silencer/pkg/metrics"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// endpoints of the alertmanager api, each with its own circuit breaker
+	endpointPostSilences  = "POST /silences"
+	endpointGetSilences   = "GET /silences"
+	endpointDeleteSilence = "DELETE /silence"
+
+	// operations on the silences of a node that are retried when they fail
+	operationSilence = "silence"
+	operationExpire  = "expire"
+)
+
+// RetryPolicy configures how failed alertmanager operations are retried and when calls to
+// an endpoint that keeps failing stop being made
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts an operation gets, including the first one
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, it doubles with every retry
+	// up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// QueueSize is the number of operations that can wait for a retry at the same time
+	QueueSize int
+	// BreakerThreshold is the number of consecutive failures that opens the circuit of an
+	// endpoint, 0 disables the circuit breaker
+	BreakerThreshold int
+	// BreakerCooldown is how long calls to an endpoint fail right away once its circuit opened
+	BreakerCooldown time.Duration
+}
+
+// newRetryPolicy returns the configured retry policy
+func newRetryPolicy() (RetryPolicy, error) {
+	p := RetryPolicy{
+		MaxAttempts:      viper.GetInt("alertmanager-retry-attempts"),
+		InitialBackoff:   viper.GetDuration("alertmanager-retry-backoff"),
+		MaxBackoff:       viper.GetDuration("alertmanager-retry-max-backoff"),
+		QueueSize:        viper.GetInt("alertmanager-retry-queue-size"),
+		BreakerThreshold: viper.GetInt("alertmanager-breaker-threshold"),
+		BreakerCooldown:  viper.GetDuration("alertmanager-breaker-cooldown"),
+	}
+
+	return p, p.Validate()
+}
+
+// Validate ensures the policy makes at least one attempt and has usable backoffs
+func (p RetryPolicy) Validate() error {
+	switch {
+	case p.MaxAttempts < 1:
+		return fmt.Errorf("%w: at least one attempt is required", ErrInvalidRetryPolicy)
+	case p.MaxAttempts > 1 && p.InitialBackoff <= 0:
+		return fmt.Errorf("%w: backoff must be positive", ErrInvalidRetryPolicy)
+	case p.MaxBackoff < p.InitialBackoff:
+		return fmt.Errorf("%w: max backoff must not be less than backoff", ErrInvalidRetryPolicy)
+	case p.QueueSize < 0:
+		return fmt.Errorf("%w: queue size must not be negative", ErrInvalidRetryPolicy)
+	case p.BreakerThreshold < 0:
+		return fmt.Errorf("%w: breaker threshold must not be negative", ErrInvalidRetryPolicy)
+	case p.BreakerThreshold > 0 && p.BreakerCooldown <= 0:
+		return fmt.Errorf("%w: breaker cooldown must be positive", ErrInvalidRetryPolicy)
+	}
+
+	return nil
+}
+
+// backoff returns the delay before the given retry. It doubles from the initial backoff up
+// to the maximum, with its upper half randomized so retries after an outage spread out.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+
+	half := d / 2
+
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// retryQueue retries failed alertmanager operations in the background and keeps a circuit
+// breaker per alertmanager endpoint. Queued operations are written to the journal, if there
+// is one, so they survive restarts. Its methods are safe to call on a nil queue, which
+// calls every endpoint and retries nothing.
+type retryQueue struct {
+	policy  RetryPolicy
+	journal Journal
+	logger  *zap.SugaredLogger
+
+	mu       sync.Mutex
+	seq      uint64
+	ops      []*JournalEntry
+	breakers map[string]*circuitBreaker
+	wake     chan struct{}
+}
+
+// operator attempts the queued operations on the silences of nodes
+type operator interface {
+	// attemptOperation runs the operation
+	attemptOperation(ctx context.Context, op JournalEntry) error
+	// stateVersion identifies the silences stored for the node
+	stateVersion(ctx context.Context, node string) (string, error)
+	// ownsNode reports whether operations on the node are run by this replica
+	ownsNode(node string) bool
+}
+
+// circuitBreaker counts the consecutive failures of an endpoint, once they reach the
+// threshold calls fail right away until the cooldown has passed. The next call is let
+// through, and opens the circuit again if it fails too.
+type circuitBreaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+func newRetryQueue(policy RetryPolicy, journal Journal, logger *zap.SugaredLogger) *retryQueue {
+	return &retryQueue{
+		policy:   policy,
+		journal:  journal,
+		logger:   logger,
+		breakers: make(map[string]*circuitBreaker),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// call runs fn unless the circuit of the endpoint is open, recording its outcome
+func (q *retryQueue) call(ctx context.Context, endpoint string, fn func(context.Context) error) error {
+	if q == nil {
+		return fn(ctx)
+	}
+
+	if until, open := q.open(endpoint); open {
+		return fmt.Errorf("%w: %s until %s", ErrCircuitOpen, endpoint, until.Format(time.RFC3339))
+	}
+
+	err := fn(ctx)
+
+	// calls cancelled on shutdown say nothing about the endpoint
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+
+	q.record(endpoint, err)
+
+	return err
+}
+
+// open returns whether the circuit of the endpoint is open and until when
+func (q *retryQueue) open(endpoint string) (time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	b, ok := q.breakers[endpoint]
+	if !ok || q.policy.BreakerThreshold == 0 {
+		return time.Time{}, false
+	}
+
+	return b.openUntil, time.Now().Before(b.openUntil)
+}
+
+// record closes the circuit of the endpoint after a successful call, and opens it once
+// failed calls reach the threshold
+func (q *retryQueue) record(endpoint string, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	b, ok := q.breakers[endpoint]
+	if !ok {
+		b = &circuitBreaker{}
+		q.breakers[endpoint] = b
+	}
+
+	threshold := q.policy.BreakerThreshold
+
+	if err == nil {
+		if threshold > 0 && b.failures >= threshold {
+			q.logger.Infow("alertmanager endpoint recovered, closing circuit", "endpoint", endpoint)
+			metrics.AlertmanagerCircuitOpen.WithLabelValues(endpoint).Set(0)
+		}
+
+		b.failures = 0
+
+		return
+	}
+
+	b.failures++
+
+	if threshold == 0 || b.failures < threshold {
+		return
+	}
+
+	b.openUntil = time.Now().Add(q.policy.BreakerCooldown)
+
+	if b.failures == threshold {
+		q.logger.Warnw("alertmanager endpoint failing, opening circuit",
+			"endpoint", endpoint, "failures", b.failures, "cooldown", q.policy.BreakerCooldown, "error", err)
+		metrics.AlertmanagerCircuitOpen.WithLabelValues(endpoint).Set(1)
+	}
+}
+
+// retry queues the operation on the node for another attempt after it failed with err,
+// version identifies the silences stored for the node. It returns err wrapped in
+// ErrRetryQueued, or err itself if the operation is given up on right away because
+// retries are disabled or the queue is full.
+func (q *retryQueue) retry(ctx context.Context, name, node, version string, err error) error {
+	if q == nil {
+		return err
+	}
+
+	q.mu.Lock()
+
+	// the queued attempt covers this failure
+	if q.queued(name, node) {
+		q.mu.Unlock()
+		return fmt.Errorf("%w: %w", ErrRetryQueued, err)
+	}
+
+	if q.policy.MaxAttempts <= 1 || len(q.ops) >= q.policy.QueueSize {
+		full := q.policy.MaxAttempts > 1
+
+		q.mu.Unlock()
+
+		if full {
+			q.logger.Warnw("alertmanager retry queue full", "size", q.policy.QueueSize)
+		}
+
+		q.fail(&JournalEntry{Operation: name, Node: node, Attempts: 1}, err)
+
+		return err
+	}
+
+	q.seq++
+
+	now := time.Now()
+	op := &JournalEntry{
+		Seq:       q.seq,
+		Operation: name,
+		Node:      node,
+		Version:   version,
+		Attempts:  1,
+		QueuedAt:  now,
+		next:      now.Add(q.policy.backoff(1)),
+	}
+
+	q.ops = append(q.ops, op)
+
+	// the run loop may already be attempting the queued operation once the lock is released
+	entry := *op
+
+	q.mu.Unlock()
+
+	q.put(ctx, &entry)
+	q.notify()
+
+	metrics.AlertmanagerRetries.WithLabelValues(name).Inc()
+	q.logger.Warnw("alertmanager operation failed, retrying", "operation", name, "node", node, "at", entry.next, "error", err)
+
+	return fmt.Errorf("%w: %w", ErrRetryQueued, err)
+}
+
+// queued reports whether the operation on the node is queued, the lock must be held
+func (q *retryQueue) queued(name, node string) bool {
+	for _, op := range q.ops {
+		if op.Operation == name && op.Node == node {
+			return true
+		}
+	}
+
+	return false
+}
+
+// drop forgets the queued operations of the node, whose labels changed since they were
+// queued, and returns how many there were
+func (q *retryQueue) drop(ctx context.Context, node string) int {
+	if q == nil {
+		return 0
+	}
+
+	q.mu.Lock()
+
+	dropped := []*JournalEntry{}
+	ops := q.ops[:0]
+
+	for _, op := range q.ops {
+		if op.Node == node {
+			dropped = append(dropped, op)
+			continue
+		}
+
+		ops = append(ops, op)
+	}
+
+	q.ops = ops
+
+	q.mu.Unlock()
+
+	for _, op := range dropped {
+		q.forget(ctx, op)
+	}
+
+	return len(dropped)
+}
+
+// reset forgets every queued operation and returns how many there were. The journal is
+// left alone, so the next replica to run the queue replays them.
+func (q *retryQueue) reset() int {
+	if q == nil {
+		return 0
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	dropped := len(q.ops)
+	q.ops = nil
+
+	return dropped
+}
+
+// run replays the journal and attempts queued operations once they are due, in the order
+// they were queued, until ctx is cancelled
+func (q *retryQueue) run(ctx context.Context, o operator) {
+	if q == nil {
+		return
+	}
+
+	q.replay(ctx, o)
+
+	for {
+		for _, op := range q.due() {
+			q.attempt(ctx, o, op)
+		}
+
+		timer := time.NewTimer(q.untilNext())
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-q.wake:
+		case <-timer.C:
+		}
+
+		timer.Stop()
+	}
+}
+
+// replay queues the journaled operations on nodes owned by this replica, they are due
+// right away
+func (q *retryQueue) replay(ctx context.Context, o operator) {
+	if q.journal == nil {
+		return
+	}
+
+	entries, err := q.journal.Load(ctx)
+	if err != nil {
+		q.logger.Errorw("unable to load alertmanager operation journal", "error", err)
+		return
+	}
+
+	q.mu.Lock()
+
+	replayed := 0
+
+	for i := range entries {
+		op := entries[i]
+
+		if op.Seq > q.seq {
+			q.seq = op.Seq
+		}
+
+		if !o.ownsNode(op.Node) || q.queued(op.Operation, op.Node) {
+			continue
+		}
+
+		op.next = time.Now()
+		q.ops = append(q.ops, &op)
+
+		replayed++
+	}
+
+	q.mu.Unlock()
+
+	if replayed > 0 {
+		q.logger.Infow("replaying journaled alertmanager operations", "operations", replayed)
+	}
+}
+
+// attempt runs the operation unless the silences stored for its node changed since it was
+// queued, queueing it again with a longer backoff if it fails and still has attempts left
+func (q *retryQueue) attempt(ctx context.Context, o operator, op *JournalEntry) {
+	version, err := o.stateVersion(ctx, op.Node)
+	if err == nil && version != op.Version {
+		q.logger.Infow("dropping alertmanager operation, node state changed since it was queued",
+			"operation", op.Operation, "node", op.Node, "queuedAt", op.QueuedAt)
+		q.forget(ctx, op)
+
+		return
+	}
+
+	if err == nil {
+		err = o.attemptOperation(ctx, *op)
+	}
+
+	switch {
+	case err == nil:
+		q.logger.Infow("alertmanager operation succeeded after retrying", "operation", op.Operation, "node", op.Node, "attempts", op.Attempts+1)
+		q.forget(ctx, op)
+
+		return
+	case ctx.Err() != nil:
+		// the journal keeps the operation for the next replica
+		return
+	}
+
+	op.Attempts++
+
+	if op.Attempts >= q.policy.MaxAttempts {
+		q.fail(op, err)
+		q.forget(ctx, op)
+
+		return
+	}
+
+	// the attempt may have expired some of the silences itself
+	if version, verr := o.stateVersion(ctx, op.Node); verr == nil {
+		op.Version = version
+	}
+
+	op.next = time.Now().Add(q.policy.backoff(op.Attempts))
+
+	q.mu.Lock()
+
+	// a failure while the operation was running queued it again
+	if q.queued(op.Operation, op.Node) {
+		q.mu.Unlock()
+		return
+	}
+
+	q.ops = append(q.ops, op)
+
+	q.mu.Unlock()
+
+	q.put(ctx, op)
+
+	q.logger.Debugw("alertmanager operation failed again", "operation", op.Operation, "node", op.Node, "attempts", op.Attempts, "error", err)
+}
+
+// fail reports an operation that is given up on
+func (q *retryQueue) fail(op *JournalEntry, err error) {
+	metrics.AlertmanagerFailures.WithLabelValues(op.Operation).Inc()
+	q.logger.Errorw("giving up on alertmanager operation", "operation", op.Operation, "node", op.Node, "attempts", op.Attempts, "error", err)
+}
+
+// put writes the operation to the journal
+func (q *retryQueue) put(ctx context.Context, op *JournalEntry) {
+	if q.journal == nil {
+		return
+	}
+
+	if err := q.journal.Put(ctx, *op); err != nil {
+		q.logger.Warnw("unable to journal alertmanager operation", "operation", op.Operation, "node", op.Node, "error", err)
+	}
+}
+
+// forget removes the operation from the journal
+func (q *retryQueue) forget(ctx context.Context, op *JournalEntry) {
+	if q.journal == nil {
+		return
+	}
+
+	if err := q.journal.Delete(ctx, *op); err != nil {
+		q.logger.Warnw("unable to remove alertmanager operation from journal", "operation", op.Operation, "node", op.Node, "error", err)
+	}
+}
+
+// due removes and returns the operations whose next attempt is due, in the order they
+// were queued
+func (q *retryQueue) due() []*JournalEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	due := []*JournalEntry{}
+	ops := q.ops[:0]
+
+	for _, op := range q.ops {
+		if op.next.After(now) {
+			ops = append(ops, op)
+			continue
+		}
+
+		due = append(due, op)
+	}
+
+	q.ops = ops
+
+	sort.Slice(due, func(a, b int) bool { return due[a].Seq < due[b].Seq })
+
+	return due
+}
+
+// untilNext returns the time until the next queued operation is due
+func (q *retryQueue) untilNext() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	wait := time.Hour
+
+	for _, op := range q.ops {
+		if d := time.Until(op.next); d < wait {
+			wait = d
+		}
+	}
+
+	return wait
+}
+
+func (q *retryQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// retrySilence queues silencing the node again
+func (srv Server) retrySilence(ctx context.Context, node string, err error) error {
+	return srv.retryOperation(ctx, operationSilence, node, err)
+}
+
+// retryExpire queues expiring the silences of the node again
+func (srv Server) retryExpire(ctx context.Context, node string, err error) error {
+	return srv.retryOperation(ctx, operationExpire, node, err)
+}
+
+func (srv Server) retryOperation(ctx context.Context, name, node string, err error) error {
+	version, verr := srv.stateVersion(ctx, node)
+	if verr != nil {
+		return err
+	}
+
+	return srv.retries.retry(ctx, name, node, version, err)
+}
+
+// attemptOperation runs a queued operation on the silences of a node
+func (srv Server) attemptOperation(ctx context.Context, op JournalEntry) error {
+	switch op.Operation {
+	case operationSilence:
+		return srv.silenceLabelled(ctx, op.Node)
+	case operationExpire:
+		return srv.expireUnlabelled(ctx, op.Node)
+	default:
+		srv.logger.Warnw("dropping unknown alertmanager operation", "operation", op.Operation, "node", op.Node)
+		return nil
+	}
+}
+
+// stateVersion identifies the silences stored for the node, it is empty for nodes that
+// are not silenced
+func (srv Server) stateVersion(ctx context.Context, node string) (string, error) {
+	state, ok, err := srv.state.Get(ctx, node)
+	if err != nil || !ok {
+		return "", err
+	}
+
+	ids := append([]string{}, state.SilenceIDs...)
+	sort.Strings(ids)
+
+	return "[" + strings.Join(ids, ",") + "]", nil
+}
+
+func (srv Server) ownsNode(node string) bool {
+	return srv.shard.owns(node)
+}
+
+// silenceLabelled silences the node if it is still labelled, owned by this replica, not
+// silenced yet and passes its pre-checks, nodes failing them are left to the pending nodes
+func (srv Server) silenceLabelled(ctx context.Context, node string) error {
+	if !srv.shard.owns(node) {
+		return nil
+	}
+
+	n, err := srv.GetKubeClient().CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if ok, err := isLabelled(n); err != nil || !ok {
+		return err
+	}
+
+	if _, silenced, err := srv.state.Get(ctx, node); err != nil || silenced {
+		return err
+	}
+
+	if err := srv.preCheck(n); err != nil {
+		srv.pending.add(node)
+		return nil
+	}
+
+	_, err = srv.silenceNode(ctx, n)
+
+	return err
+}
+
+// expireUnlabelled expires the stored silences of the node, unless it was labelled again
+// or is owned by another replica
+func (srv Server) expireUnlabelled(ctx context.Context, node string) error {
+	if !srv.shard.owns(node) {
+		return nil
+	}
+
+	n, err := srv.GetKubeClient().CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return err
+	default:
+		if ok, err := isLabelled(n); err != nil || ok {
+			return err
+		}
+	}
+
+	state, ok, err := srv.state.Get(ctx, node)
+	if err != nil || !ok {
+		return err
+	}
+
+	return srv.expireSilences(ctx, node, state.SilenceIDs)
+}
+
+// isLabelled reports whether the node carries the kured label
+func isLabelled(node *v1.Node) (bool, error) {
+	selector, err := labels.Parse(viper.GetString("kured-label"))
+	if err != nil {
+		return false, err
+	}
+
+	return selector.Matches(labels.Set(node.Labels)), nil
+}
+
+// postSilence posts the silences through the circuit breaker of the endpoint
+func (srv Server) postSilence(ctx context.Context, silences []alertmanager.Silence) ([]string, error) {
+	var ids []string
+
+	err := srv.retries.call(ctx, endpointPostSilences, func(ctx context.Context) error {
+		var err error
+
+		ids, err = alertmanager.PostSilence(ctx, srv.Client.AMClient, silences, srv.silenceDuration)
+
+		return err
+	})
+
+	return ids, err
+}
+
+// extendSilence extends the silence through the circuit breaker of the endpoint
+func (srv Server) extendSilence(ctx context.Context, id string, endsAt time.Time) (string, error) {
+	var renewed string
+
+	err := srv.retries.call(ctx, endpointPostSilences, func(ctx context.Context) error {
+		var err error
+
+		renewed, err = alertmanager.ExtendSilence(ctx, srv.Client.AMClient, id, endsAt)
+
+		return err
+	})
+
+	return renewed, err
+}
+
+// deleteSilence deletes the silence through the circuit breaker of the endpoint
+func (srv Server) deleteSilence(ctx context.Context, id string) error {
+	return srv.retries.call(ctx, endpointDeleteSilence, func(ctx context.Context) error {
+		return alertmanager.DeleteSilence(ctx, srv.Client.AMClient, id)
+	})
+}
+
+// nodeSilences lists the silences of each node with their matchers through the circuit
+// breaker of the endpoint
+func (srv Server) nodeSilences(ctx context.Context) (map[string]models.GettableSilences, error) {
+	var nodes map[string]models.GettableSilences
+
+	err := srv.retries.call(ctx, endpointGetSilences, func(ctx context.Context) error {
+		var err error
+
+		nodes, err = alertmanager.NodeSilences(ctx, srv.Client.AMClient)
+
+		return err
+	})
+
+	return nodes, err
+}
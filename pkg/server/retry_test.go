@@ -0,0 +1,207 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/metrics"
+	"github.com/tylerauerbeck/kured-silencer/pkg/server"
+
+	"go.uber.org/zap"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRetryPolicyValidate(t *testing.T) {
+	valid := server.RetryPolicy{
+		MaxAttempts:      5,
+		InitialBackoff:   time.Second,
+		MaxBackoff:       time.Minute,
+		QueueSize:        100,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+	}
+
+	type testCase struct {
+		name           string
+		modify         func(p *server.RetryPolicy)
+		expectedErrors []error
+	}
+
+	testCases := []testCase{
+		{
+			name:   "valid",
+			modify: func(p *server.RetryPolicy) {},
+		},
+		{
+			name: "retries and circuit breaker disabled",
+			modify: func(p *server.RetryPolicy) {
+				p.MaxAttempts = 1
+				p.InitialBackoff = 0
+				p.MaxBackoff = 0
+				p.BreakerThreshold = 0
+				p.BreakerCooldown = 0
+			},
+		},
+		{
+			name:           "no attempts",
+			modify:         func(p *server.RetryPolicy) { p.MaxAttempts = 0 },
+			expectedErrors: []error{server.ErrInvalidRetryPolicy},
+		},
+		{
+			name:           "missing backoff",
+			modify:         func(p *server.RetryPolicy) { p.InitialBackoff = 0 },
+			expectedErrors: []error{server.ErrInvalidRetryPolicy},
+		},
+		{
+			name:           "max backoff below backoff",
+			modify:         func(p *server.RetryPolicy) { p.MaxBackoff = time.Millisecond },
+			expectedErrors: []error{server.ErrInvalidRetryPolicy},
+		},
+		{
+			name:           "missing breaker cooldown",
+			modify:         func(p *server.RetryPolicy) { p.BreakerCooldown = 0 },
+			expectedErrors: []error{server.ErrInvalidRetryPolicy},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := valid
+			tc.modify(&p)
+
+			err := p.Validate()
+
+			if len(tc.expectedErrors) > 0 {
+				assert.Error(t, err)
+				for _, expectedError := range tc.expectedErrors {
+					assert.ErrorIs(t, err, expectedError)
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRetryQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fam, amc := newFakeAlertManager(t, func() models.GettableAlerts { return models.GettableAlerts{} })
+	fam.nodeSilence("id-1", "node-1")
+	fam.nodeSilence("id-2", "node-1")
+	fam.nodeSilence("id-3", "node-2")
+	fam.setUnavailable(true)
+
+	state := server.NewMemoryStateStore()
+	assert.NoError(t, state.Set(ctx, "node-1", server.NodeState{SilenceIDs: []string{"id-1", "id-2"}}))
+	assert.NoError(t, state.Set(ctx, "node-2", server.NodeState{SilenceIDs: []string{"id-3"}}))
+
+	srv := server.Server{
+		Client: &server.Client{
+			KubeClient: fake.NewSimpleClientset(),
+			AMClient:   amc,
+		},
+	}.WithLogger(ctx, zap.NewNop().Sugar()).
+		WithStateStore(ctx, state).
+		WithRemovalBuffer(ctx, time.Hour).
+		WithRetryPolicy(ctx, server.RetryPolicy{
+			MaxAttempts:    4,
+			InitialBackoff: 10 * time.Millisecond,
+			MaxBackoff:     40 * time.Millisecond,
+			QueueSize:      10,
+		})
+
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		srv.Run(ctx)
+	}()
+
+	// restoring the state fails while alertmanager is unavailable
+	assert.Eventually(t, func() bool { return fam.requestCount() > 0 }, time.Second, 10*time.Millisecond)
+
+	// expiring the silences succeeds once alertmanager is back
+	assert.ErrorIs(t, srv.NodeDeleted(ctx, "node-1"), server.ErrRetryQueued)
+
+	fam.setUnavailable(false)
+
+	assert.Eventually(t, func() bool {
+		_, ok, err := state.Get(ctx, "node-1")
+		return err == nil && !ok
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, []string{"id-1", "id-2"}, fam.deletedSilences())
+
+	// operations that exhaust their attempts are given up on
+	failures := testutil.ToFloat64(metrics.AlertmanagerFailures.WithLabelValues("expire"))
+
+	fam.setUnavailable(true)
+
+	assert.ErrorIs(t, srv.NodeDeleted(ctx, "node-2"), server.ErrRetryQueued)
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.AlertmanagerFailures.WithLabelValues("expire")) == failures+1
+	}, time.Second, 10*time.Millisecond)
+
+	ns, ok, err := state.Get(ctx, "node-2")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"id-3"}, ns.SilenceIDs)
+
+	cancel()
+	<-stopped
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+
+	fam, amc := newFakeAlertManager(t, func() models.GettableAlerts { return models.GettableAlerts{} })
+	fam.setUnavailable(true)
+
+	state := server.NewMemoryStateStore()
+	assert.NoError(t, state.Set(ctx, "node-1", server.NodeState{SilenceIDs: []string{"id-1"}}))
+
+	srv := server.Server{
+		Client: &server.Client{
+			KubeClient: fake.NewSimpleClientset(),
+			AMClient:   amc,
+		},
+	}.WithLogger(ctx, zap.NewNop().Sugar()).
+		WithStateStore(ctx, state).
+		WithRetryPolicy(ctx, server.RetryPolicy{
+			MaxAttempts:      1,
+			BreakerThreshold: 2,
+			BreakerCooldown:  100 * time.Millisecond,
+		})
+
+	for i := 0; i < 2; i++ {
+		err := srv.NodeDeleted(ctx, "node-1")
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, server.ErrCircuitOpen)
+	}
+
+	// the circuit is open, calls fail without reaching alertmanager
+	requests := fam.requestCount()
+
+	assert.ErrorIs(t, srv.NodeDeleted(ctx, "node-1"), server.ErrCircuitOpen)
+	assert.Equal(t, requests, fam.requestCount())
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.AlertmanagerCircuitOpen.WithLabelValues("DELETE /silence")))
+
+	// the circuit closes once a call after the cooldown succeeds
+	fam.setUnavailable(false)
+
+	assert.Eventually(t, func() bool {
+		return srv.NodeDeleted(ctx, "node-1") == nil
+	}, time.Second, 20*time.Millisecond)
+
+	assert.Equal(t, []string{"id-1"}, fam.deletedSilences())
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.AlertmanagerCircuitOpen.WithLabelValues("DELETE /silence")))
+}
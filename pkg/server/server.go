@@ -2,33 +2,33 @@ package server
 
 import (
 	"context"
+	"errors"
+	"math"
 	"net/url"
-	"os"
+	"sync/atomic"
 	"time"
 
+	"github.com/mitchellh/mapstructure"
+	"github.com/prometheus/alertmanager/api/v2/models"
 	"github.com/spf13/viper"
 
 	"github.com/tylerauerbeck/kured-silencer/pkg/alertmanager"
 	"github.com/tylerauerbeck/kured-silencer/pkg/kube"
+	"github.com/tylerauerbeck/kured-silencer/pkg/prometheus"
 
 	"go.uber.org/zap"
 
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
-)
-
-var (
-	silenceIDs           = make(map[string][]string)
-	defaultLeaseDuration = 15 * time.Second
-	defaultRenewDeadline = 10 * time.Second
-	defaultRetryPeriod   = 2 * time.Second
-	leaseLockName        = "kured-silencer"
-	leaseLockNamespace   = os.Getenv("POD_NAMESPACE")
-	podName              = os.Getenv("POD_NAME")
+	"k8s.io/client-go/tools/record"
 )
 
 // NewServer creates a new server
@@ -49,19 +49,171 @@ func NewServer(ctx context.Context, logger *zap.SugaredLogger) (*Server, error)
 
 	amcli := alertmanager.NewSilencerClient(context.TODO(), url)
 
+	silences := []alertmanager.Silence{}
+	hook := viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		alertmanager.MatchersDecodeHook(),
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	))
+
+	if err := viper.UnmarshalKey("silences", &silences, hook); err != nil {
+		return nil, err
+	}
+
+	if err := alertmanager.ValidateSilences(silences); err != nil {
+		return nil, err
+	}
+
+	promcli, err := newPrometheusClient(ctx, silences)
+	if err != nil {
+		return nil, err
+	}
+
+	preChecks := []PreCheck{}
+	if err := viper.UnmarshalKey("pre-checks", &preChecks, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		PreChecksDecodeHook(),
+		mapstructure.StringToSliceHookFunc(","),
+	))); err != nil {
+		return nil, err
+	}
+
+	if err := ValidatePreChecks(preChecks); err != nil {
+		return nil, err
+	}
+
+	podSelector, err := parsePodSelector(viper.GetString("removal-pod-selector"))
+	if err != nil {
+		return nil, err
+	}
+
+	switch viper.GetString("on-shutdown") {
+	case OnShutdownKeep, OnShutdownExpire, "":
+	default:
+		return nil, ErrUnknownShutdownAction
+	}
+
+	switch viper.GetString("node-watcher") {
+	case NodeWatcherInformer, NodeWatcherWatch, "":
+	default:
+		return nil, ErrUnknownNodeWatcher
+	}
+
+	switch viper.GetString("removal-strategy") {
+	case RemovalStrategyBuffer, RemovalStrategyAlertsResolved, "":
+	default:
+		return nil, ErrUnknownRemovalStrategy
+	}
+
+	election, err := newLeaderElection()
+	if err != nil {
+		return nil, err
+	}
+
+	var nodeShard *shard
+
+	if viper.GetBool("sharding") {
+		// replicas share the work instead of electing a leader
+		election.Enabled = true
+		if err := election.Validate(); err != nil {
+			return nil, err
+		}
+
+		election.Enabled = false
+		nodeShard = newShard(kcli, election, logger)
+	}
+
+	retryPolicy, err := newRetryPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := viper.GetString("state-store-namespace")
+	if namespace == "" {
+		namespace = podNamespace(viper.GetString("leader-elect-namespace"))
+	}
+
+	state, err := NewStateStore(viper.GetString("state-store"), kcli, namespace, viper.GetString("state-store-name"))
+	if err != nil {
+		return nil, err
+	}
+
+	journal, err := NewJournal(viper.GetString("journal"), kcli, namespace, viper.GetString("journal-name"), viper.GetString("journal-path"))
+	if err != nil {
+		return nil, err
+	}
+
+	// journaled operations are dropped once the stored state of their node changed, which
+	// it always has for a memory state store that was not restored after a restart
+	switch viper.GetString("state-store") {
+	case StateStoreMemory, "":
+		if journal != nil {
+			return nil, ErrJournalWithoutStateStore
+		}
+	}
+
 	srv := &Server{
 		Client: &Client{
 			KubeClient: kcli,
 			AMClient:   amcli,
+			PromClient: promcli,
 		},
-		logger:          logger,
-		silenceDuration: viper.GetDuration("silence-duration"),
-		removalBuffer:   viper.GetDuration("removal-buffer"),
+		state:               state,
+		election:            election,
+		shard:               nodeShard,
+		journal:             journal,
+		retries:             newRetryQueue(retryPolicy, journal, logger),
+		logger:              logger,
+		silenceDuration:     viper.GetDuration("silence-duration"),
+		silenceMaxDuration:  viper.GetDuration("silence-max-duration"),
+		removalBuffer:       viper.GetDuration("removal-buffer"),
+		reconcileInterval:   viper.GetDuration("reconcile-interval"),
+		nodeWatcher:         viper.GetString("node-watcher"),
+		removals:            newRemovalScheduler(),
+		pending:             newPendingNodes(),
+		pendingInterval:     viper.GetDuration("pending-retry-interval"),
+		onShutdown:          viper.GetString("on-shutdown"),
+		shutdownTimeout:     viper.GetDuration("shutdown-timeout"),
+		removalStrategy:     viper.GetString("removal-strategy"),
+		removalMaxWait:      viper.GetDuration("removal-max-wait"),
+		removalPollInterval: viper.GetDuration("removal-poll-interval"),
+		recorder:            kube.NewEventRecorder(kcli, "kured-silencer"),
+		waitForPods:         viper.GetBool("removal-wait-for-pods"),
+		podSelector:         podSelector,
+		podsTimeout:         viper.GetDuration("removal-pods-timeout"),
+		silences:            silences,
+		preChecks:           preChecks,
 	}
 
 	return srv, nil
 }
 
+// newPrometheusClient returns a client for the configured prometheus endpoint, which is
+// required when any silence has a removeWhen query
+func newPrometheusClient(ctx context.Context, silences []alertmanager.Silence) (*prometheus.Client, error) {
+	endpoint := viper.GetString("prometheus-endpoint")
+
+	if endpoint == "" {
+		for _, s := range silences {
+			if s.RemoveWhen != "" {
+				return nil, ErrMissingPrometheusEndpoint
+			}
+		}
+
+		return nil, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ValidateURL(u); err != nil {
+		return nil, err
+	}
+
+	return prometheus.NewClient(ctx, u), nil
+}
+
 // WithLogger sets the logger for the server
 func (srv Server) WithLogger(_ context.Context, logger *zap.SugaredLogger) *Server {
 	srv.logger = logger
@@ -74,6 +226,126 @@ func (srv Server) WithSilenceDuration(_ context.Context, d time.Duration) *Serve
 	return &srv
 }
 
+// WithSilenceMaxDuration sets how long silences of a node that is still labelled are
+// renewed for, zero disables renewal
+func (srv Server) WithSilenceMaxDuration(_ context.Context, d time.Duration) *Server {
+	srv.silenceMaxDuration = d
+	return &srv
+}
+
+// WithPreChecks sets the checks a node has to pass before it is silenced
+func (srv Server) WithPreChecks(_ context.Context, checks []PreCheck) *Server {
+	srv.preChecks = checks
+	return &srv
+}
+
+// WithSilences sets the silence definitions posted for each node
+func (srv Server) WithSilences(_ context.Context, silences []alertmanager.Silence) *Server {
+	srv.silences = silences
+	return &srv
+}
+
+// WithRemovalBuffer sets how long silences are kept after a node loses its label
+func (srv Server) WithRemovalBuffer(_ context.Context, d time.Duration) *Server {
+	srv.removalBuffer = d
+
+	if srv.removals == nil {
+		srv.removals = newRemovalScheduler()
+	}
+
+	return &srv
+}
+
+// WithRemovalStrategy sets how silences are removed once a node loses its label, waiting
+// at most maxWait and polling every interval for strategies that wait on alertmanager
+func (srv Server) WithRemovalStrategy(_ context.Context, strategy string, maxWait, interval time.Duration) *Server {
+	srv.removalStrategy = strategy
+	srv.removalMaxWait = maxWait
+	srv.removalPollInterval = interval
+
+	return &srv
+}
+
+// WithPodsReadyGate makes silence removal wait until the DaemonSet pods and the pods
+// matching selector on the node are ready, for at most timeout
+func (srv Server) WithPodsReadyGate(_ context.Context, selector labels.Selector, timeout time.Duration) *Server {
+	srv.waitForPods = true
+	srv.podSelector = selector
+	srv.podsTimeout = timeout
+
+	return &srv
+}
+
+// WithEventRecorder sets the recorder kubernetes events are emitted with
+func (srv Server) WithEventRecorder(_ context.Context, recorder record.EventRecorder) *Server {
+	srv.recorder = recorder
+	return &srv
+}
+
+// WithReconcileInterval sets how often labelled nodes and silences are reconciled
+func (srv Server) WithReconcileInterval(_ context.Context, d time.Duration) *Server {
+	srv.reconcileInterval = d
+	return &srv
+}
+
+// WithPendingRetryInterval sets how often nodes that failed a pre-check are reconsidered
+func (srv Server) WithPendingRetryInterval(_ context.Context, d time.Duration) *Server {
+	srv.pendingInterval = d
+
+	if srv.pending == nil {
+		srv.pending = newPendingNodes()
+	}
+
+	return &srv
+}
+
+// WithShutdown sets what happens to active silences on shutdown and how long work in
+// flight is given to complete
+func (srv Server) WithShutdown(_ context.Context, onShutdown string, timeout time.Duration) *Server {
+	srv.onShutdown = onShutdown
+	srv.shutdownTimeout = timeout
+
+	return &srv
+}
+
+// WithLeaderElection sets how the replica handling nodes is elected
+func (srv Server) WithLeaderElection(_ context.Context, election LeaderElection) *Server {
+	srv.election = election
+	return &srv
+}
+
+// WithSharding splits nodes between the replicas renewing a Lease with the election's
+// lock name, instead of electing a leader
+func (srv Server) WithSharding(_ context.Context, election LeaderElection) *Server {
+	srv.shard = newShard(srv.GetKubeClient(), election, srv.logger)
+	return &srv
+}
+
+// WithRetryPolicy sets how failed alertmanager operations are retried. It uses the logger
+// of the server, so WithLogger has to be called first.
+func (srv Server) WithRetryPolicy(_ context.Context, policy RetryPolicy) *Server {
+	srv.retries = newRetryQueue(policy, srv.journal, srv.logger)
+	return &srv
+}
+
+// WithJournal sets the journal queued alertmanager operations are persisted to, it only
+// takes effect once a retry policy is set
+func (srv Server) WithJournal(_ context.Context, journal Journal) *Server {
+	srv.journal = journal
+
+	if srv.retries != nil {
+		srv.retries = newRetryQueue(srv.retries.policy, journal, srv.logger)
+	}
+
+	return &srv
+}
+
+// WithStateStore sets the store the node to silence mapping is kept in
+func (srv Server) WithStateStore(_ context.Context, state StateStore) *Server {
+	srv.state = state
+	return &srv
+}
+
 // GetKubeClient returns the kubernetes client from the running server
 func (srv Server) GetKubeClient() kubernetes.Interface {
 	return srv.Client.KubeClient
@@ -82,73 +354,341 @@ func (srv Server) GetKubeClient() kubernetes.Interface {
 // EventHandler provides logic for handling node label event types
 func (srv Server) EventHandler(ctx context.Context, event watch.Event) error {
 	switch event.Type {
-	case watch.Added:
-		if err := isNodeReady(event.Object.(*v1.Node)); err != nil {
-			srv.logger.Errorw("node not ready", "node", event.Object.(*v1.Node).Name)
-			return err
+	case watch.Added, watch.Modified:
+		// nodes owned by another replica are handled there
+		if !srv.shard.owns(event.Object.(*v1.Node).Name) {
+			return nil
 		}
 
-		silencedIDs, err := alertmanager.PostSilence(ctx, srv.Client.AMClient, srv.silenceDuration)
+		// changes to labelled nodes only matter for nodes that failed a pre-check
+		if event.Type == watch.Modified && !srv.pending.has(event.Object.(*v1.Node).Name) {
+			return nil
+		}
+
+		// a retry queued before the label changed would act on an outdated node
+		srv.retries.drop(ctx, event.Object.(*v1.Node).Name)
+
+		if srv.removals.cancel(event.Object.(*v1.Node).Name) {
+			srv.logger.Infow("label re-added, silence removal cancelled", "node", event.Object.(*v1.Node).Name)
+		}
+
+		_, silenced, err := srv.state.Get(ctx, event.Object.(*v1.Node).Name)
 		if err != nil {
-			if len(silencedIDs) > 0 {
-				for _, id := range silencedIDs {
-					if err := alertmanager.DeleteSilence(ctx, srv.Client.AMClient, id); err != nil {
-						return err
-					}
-				}
-			}
+			return err
+		}
+
+		// stuck nodes keep their state and are not silenced again until the label is removed
+		if silenced {
+			srv.pending.remove(event.Object.(*v1.Node).Name)
+			srv.logger.Debugw("node already silenced", "node", event.Object.(*v1.Node).Name)
+
+			return nil
+		}
+
+		if err := srv.preCheck(event.Object.(*v1.Node)); err != nil {
+			srv.pending.add(event.Object.(*v1.Node).Name)
+			srv.logger.Errorw("node failed pre-check, retrying", "node", event.Object.(*v1.Node).Name, "error", err)
 
 			return err
 		}
 
-		silenceIDs[event.Object.(*v1.Node).Name] = silencedIDs
+		if _, err := srv.silenceNode(ctx, event.Object.(*v1.Node)); err != nil {
+			return srv.retrySilence(ctx, event.Object.(*v1.Node).Name, err)
+		}
+
+		srv.pending.remove(event.Object.(*v1.Node).Name)
 
 		srv.logger.Infow("label added", "node", event.Object.(*v1.Node).Name)
 
 		return nil
 	case watch.Deleted:
-		// TODO: probably a better way to do this, but we're finding that we get alerted once
-		// the silence is removed because there are alerts that haven't cleared. This is a
-		// configurable period of time, but it would be better to have a smarter way to handle
-		// this
-
-		time.Sleep(srv.removalBuffer)
-
-		ids, ok := silenceIDs[event.Object.(*v1.Node).Name]
-		if ok {
-			for _, id := range ids {
-				if err := alertmanager.DeleteSilence(ctx, srv.Client.AMClient, id); err != nil {
-					// TODO: emit metric that we failed to delete a set of silences
-					return err
-				}
-			}
+		name := event.Object.(*v1.Node).Name
 
-			delete(silenceIDs, event.Object.(*v1.Node).Name)
+		if !srv.shard.owns(name) {
+			return nil
+		}
 
-			srv.logger.Infow("label removed", "node", event.Object.(*v1.Node).Name)
+		srv.pending.remove(name)
+		srv.retries.drop(ctx, name)
 
-			return nil
+		_, silenced, err := srv.state.Get(ctx, name)
+		if err != nil {
+			return err
 		}
 
-		return ErrMissingNode
+		if !silenced {
+			return ErrMissingNode
+		}
+
+		srv.scheduleRemoval(ctx, name, nil)
+
+		return nil
 	default:
 		return nil
 	}
 }
 
-// Run starts the server
+// scheduleRemoval removes the silences of a node that lost its label once the removal
+// buffer has passed, reporting whether a new removal was scheduled. If set, removed is
+// called with the number of silences expired once the removal finished.
+func (srv Server) scheduleRemoval(ctx context.Context, name string, removed func(int)) bool {
+	// TODO: probably a better way to do this, but we're finding that we get alerted once
+	// the silence is removed because there are alerts that haven't cleared. This is a
+	// configurable period of time, but it would be better to have a smarter way to handle
+	// this
+	// the silences are renewed while the removal waits for its gates, so they do not end
+	// before the removal expires them
+	until := time.Now().Add(srv.removalBuffer + srv.removalWait())
+
+	scheduled := srv.removals.schedule(ctx, name, srv.removalBuffer, until, func(ctx context.Context) {
+		expired, err := srv.removeSilences(ctx, name)
+
+		if removed != nil {
+			removed(expired)
+		}
+
+		if err != nil {
+			// removals cancelled by the label being re-added are not retried
+			if ctx.Err() == nil && !errors.Is(err, ErrMissingNode) {
+				err = srv.retryExpire(ctx, name, err)
+			}
+
+			if !errors.Is(err, ErrRetryQueued) {
+				srv.logger.Errorw("unable to remove silences", "node", name, "error", err)
+			}
+
+			return
+		}
+
+		srv.logger.Infow("label removed", "node", name)
+	})
+
+	if scheduled {
+		srv.logger.Infow("silence removal scheduled", "node", name, "after", srv.removalBuffer)
+	}
+
+	return scheduled
+}
+
+// silenceNode posts the silences for the node and records them in the state store
+func (srv Server) silenceNode(ctx context.Context, node *v1.Node) ([]string, error) {
+	silences, err := srv.silencesFor(node)
+	if err != nil {
+		srv.logger.Errorw("unable to render silences", "node", node.Name, "error", err)
+		return nil, err
+	}
+
+	silencedIDs, err := srv.postSilence(ctx, silences)
+	if err != nil {
+		if len(silencedIDs) > 0 {
+			for _, id := range silencedIDs {
+				if err := srv.deleteSilence(ctx, id); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		return nil, err
+	}
+
+	state := NodeState{
+		SilenceIDs: silencedIDs,
+		BootID:     node.Status.NodeInfo.BootID,
+		SilencedAt: time.Now(),
+	}
+
+	for i, s := range silences {
+		if s.RemoveWhen == "" {
+			continue
+		}
+
+		if state.RemoveWhen == nil {
+			state.RemoveWhen = make(map[string]string)
+		}
+
+		state.RemoveWhen[silencedIDs[i]] = s.RemoveWhen
+	}
+
+	if err := srv.state.Set(ctx, node.Name, state); err != nil {
+		srv.logger.Errorw("unable to store node state", "node", node.Name, "error", err)
+		return nil, err
+	}
+
+	return silencedIDs, nil
+}
+
+// Run starts the server and blocks until ctx is cancelled and the server has shut down
 func (srv *Server) Run(ctx context.Context) {
-	if viper.GetString("kubeconfig-path") == "" {
-		client := srv.GetKubeClient().(*kubernetes.Clientset)
-		lock := getNewLock(client, leaseLockName, podName, leaseLockNamespace)
-		srv.runLeaderElection(ctx, lock, os.Getenv("POD_NAME"))
-	} else {
-		for {
-			if err := srv.watcherRun(ctx); err != nil {
-				srv.logger.Infow("restarting watcher...", "error", err.Error())
+	switch {
+	case srv.shard != nil:
+		srv.runSharded(ctx)
+	case srv.election.Enabled:
+		srv.runLeaderElection(ctx, srv.election.lock(srv.GetKubeClient()), srv.election.Identity)
+	default:
+		srv.lead(ctx, context.Background())
+		srv.shutdown()
+	}
+}
+
+var (
+	// watcherBackoff spaces out restarts of a failing node watcher, it starts over once
+	// the watcher ran for longer than the cap
+	watcherBackoff = wait.Backoff{Duration: time.Second, Factor: 2, Jitter: 0.1, Steps: math.MaxInt32, Cap: time.Minute}
+)
+
+// lead restores the node state and observes nodes until ctx is cancelled, then steps down.
+// Work in flight when ctx is cancelled is given the shutdown timeout to complete, unless
+// lease is cancelled as well because leadership was lost.
+func (srv *Server) lead(ctx, lease context.Context) {
+	work, cancel := srv.drainContext(ctx, lease)
+	defer cancel()
+
+	srv.restoreState(work)
+
+	// journaled operations are checked against the restored state before they are replayed
+	go srv.retries.run(work, srv)
+
+	backoff := watcherBackoff
+
+	for ctx.Err() == nil {
+		started := time.Now()
+
+		err := srv.nodesRun(ctx, work)
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+
+		if time.Since(started) > backoff.Cap {
+			backoff = watcherBackoff
+		}
+
+		delay := backoff.Step()
+
+		srv.logger.Infow("restarting watcher...", "error", err.Error(), "delay", delay)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+
+	srv.stepDown()
+}
+
+// restoreState rebuilds the node to silence mapping from the active silences in
+// alertmanager, so silences created before a restart or failover are still removed
+func (srv *Server) restoreState(ctx context.Context) {
+	nodes, err := srv.nodeSilences(ctx)
+	if err != nil {
+		srv.logger.Errorw("unable to restore silences from alertmanager", "error", err)
+		return
+	}
+
+	// nodes whose silences were removed while another replica was leading
+	stored, err := srv.state.List(ctx)
+	if err != nil {
+		srv.logger.Errorw("unable to list stored node state", "error", err)
+	}
+
+	for node, state := range stored {
+		if _, ok := nodes[node]; ok || !srv.shard.owns(node) {
+			continue
+		}
+
+		// a node stuck past the maximum silence duration is not silenced again while it
+		// keeps the label, its silences ended on purpose
+		if state.Stuck {
+			state.SilenceIDs = nil
+			state.RemoveWhen = nil
+
+			if err := srv.state.Set(ctx, node, state); err != nil {
+				srv.logger.Errorw("unable to store stuck node state", "node", node, "error", err)
 			}
+
+			continue
+		}
+
+		if err := srv.state.Delete(ctx, node); err != nil {
+			srv.logger.Errorw("unable to delete stale node state", "node", node, "error", err)
+		}
+	}
+
+	for node, silences := range nodes {
+		if !srv.shard.owns(node) {
+			continue
 		}
+
+		// keep what a persistent state store knows beyond the silence ids
+		state, _, err := srv.state.Get(ctx, node)
+		if err != nil {
+			srv.logger.Errorw("unable to get stored node state", "node", node, "error", err)
+		}
+
+		state.SilenceIDs = silenceIDs(silences)
+
+		if queries, ok := srv.restoreRemoveWhen(ctx, node, silences); ok {
+			state.RemoveWhen = queries
+		}
+
+		if err := srv.state.Set(ctx, node, state); err != nil {
+			srv.logger.Errorw("unable to store restored node state", "node", node, "error", err)
+		}
+	}
+
+	srv.logger.Infow("restored silences from alertmanager", "nodes", len(nodes))
+}
+
+// restoreRemoveWhen finds the removeWhen queries of the silences of a node by matching
+// them against the silence definitions rendered for the node, so a state store that lost
+// them still keeps the silences until their queries return no samples, and stored queries
+// of definitions that were since changed or removed are dropped. It reports false if the
+// node could not be rendered, the stored queries are then kept.
+func (srv Server) restoreRemoveWhen(ctx context.Context, name string, silences models.GettableSilences) (map[string]string, bool) {
+	queries := false
+
+	for _, s := range srv.silences {
+		queries = queries || s.RemoveWhen != ""
+	}
+
+	if !queries {
+		return nil, true
+	}
+
+	node, err := srv.GetKubeClient().CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		srv.logger.Warnw("unable to get node to restore removal queries", "node", name, "error", err)
+		return nil, false
+	}
+
+	rendered, err := srv.silencesFor(node)
+	if err != nil {
+		srv.logger.Warnw("unable to render silences to restore removal queries", "node", name, "error", err)
+		return nil, false
+	}
+
+	return alertmanager.RemoveWhen(silences, rendered), true
+}
+
+// silenceIDs returns the ids of the given silences
+func silenceIDs(silences models.GettableSilences) []string {
+	ids := make([]string, 0, len(silences))
+
+	for _, gs := range silences {
+		ids = append(ids, *gs.ID)
 	}
+
+	return ids
+}
+
+// silencesFor renders the configured silence definitions for the given node, falling
+// back to silencing the alerts identifying the node when none are configured
+func (srv Server) silencesFor(node *v1.Node) ([]alertmanager.Silence, error) {
+	silences := srv.silences
+	if len(silences) == 0 {
+		silences = alertmanager.DefaultSilences()
+	}
+
+	return alertmanager.RenderSilences(silences, node)
 }
 
 // ValidateURL ensures that a valid url with both scheme and host is provided
@@ -164,34 +704,56 @@ func ValidateURL(u *url.URL) error {
 	return nil
 }
 
-func getNewLock(client *kubernetes.Clientset, lockname, podname, namespace string) *resourcelock.LeaseLock {
-	return &resourcelock.LeaseLock{
-		LeaseMeta: metav1.ObjectMeta{
-			Name:      lockname,
-			Namespace: namespace,
-		},
-		Client: client.CoordinationV1(),
-		LockConfig: resourcelock.ResourceLockConfig{
-			Identity: podname,
-		},
+func (srv *Server) runLeaderElection(ctx context.Context, lock *resourcelock.LeaseLock, id string) {
+	// the lease is released once the leader has shut down rather than as soon as ctx is
+	// cancelled, replicas that are not leading stop right away
+	electionCtx, release := context.WithCancel(context.Background())
+	defer release()
+
+	var leading atomic.Bool
+
+	go func() {
+		<-ctx.Done()
+
+		if !leading.Load() {
+			release()
+		}
+	}()
+
+	term := func(lease context.Context) {
+		leading.Store(true)
+
+		// lease is only cancelled when the lease is lost, leading also stops on shutdown
+		c, cancel := context.WithCancel(lease)
+		defer cancel()
+
+		go func() {
+			select {
+			case <-ctx.Done():
+				cancel()
+			case <-c.Done():
+			}
+		}()
+
+		srv.lead(c, lease)
+
+		if ctx.Err() != nil {
+			srv.shutdown()
+			release()
+
+			return
+		}
+
+		leading.Store(false)
 	}
-}
 
-func (srv *Server) runLeaderElection(ctx context.Context, lock *resourcelock.LeaseLock, id string) {
-	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+	config := leaderelection.LeaderElectionConfig{
 		Lock:            lock,
 		ReleaseOnCancel: true,
-		LeaseDuration:   defaultLeaseDuration,
-		RenewDeadline:   defaultRenewDeadline,
-		RetryPeriod:     defaultRetryPeriod,
+		LeaseDuration:   srv.election.LeaseDuration,
+		RenewDeadline:   srv.election.RenewDeadline,
+		RetryPeriod:     srv.election.RetryPeriod,
 		Callbacks: leaderelection.LeaderCallbacks{
-			OnStartedLeading: func(c context.Context) {
-				for {
-					if err := srv.watcherRun(ctx); err != nil {
-						srv.logger.Info("Watcher closed", "error", err.Error())
-					}
-				}
-			},
 			OnStoppedLeading: func() {
 				srv.logger.Info("new leader elected, stepping down...")
 			},
@@ -203,44 +765,174 @@ func (srv *Server) runLeaderElection(ctx context.Context, lock *resourcelock.Lea
 				srv.logger.Infow("new leader elected", "leader", current_id)
 			},
 		},
-	})
+	}
+
+	// a replica that lost the lease stands for election again until ctx is cancelled
+	for ctx.Err() == nil && electionCtx.Err() == nil {
+		done := make(chan struct{})
+
+		config.Callbacks.OnStartedLeading = func(lease context.Context) {
+			defer close(done)
+
+			term(lease)
+		}
+
+		leaderelection.RunOrDie(electionCtx, config)
+
+		// RunOrDie returns without waiting for OnStartedLeading, the next term only starts
+		// once this one stepped down. Without electionCtx being cancelled, RunOrDie only
+		// returns after the lease was acquired.
+		if electionCtx.Err() == nil {
+			<-done
+		}
+	}
+}
+
+// nodesRun observes labelled nodes using the configured node watcher until ctx is
+// cancelled. Nodes are handled with the work context, which outlives ctx on shutdown.
+func (srv *Server) nodesRun(ctx, work context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := srv.deletionRun(ctx, work); err != nil {
+		return err
+	}
+
+	if srv.nodeWatcher == NodeWatcherWatch {
+		return srv.watcherRun(ctx, work)
+	}
+
+	return srv.informerRun(ctx, work)
 }
 
-func (srv *Server) watcherRun(ctx context.Context) error {
+// watcherRun observes labelled nodes through a plain watch. The watch is resumed from the
+// last seen resource version whenever it closes, and nodes are only relisted when that
+// resource version is too old for the api server to resume from.
+func (srv *Server) watcherRun(ctx, work context.Context) error {
+	label := viper.GetString("kured-label")
 
-	watcher, err := kube.NewNodeWatcher(ctx, srv.GetKubeClient(), viper.GetString("kured-label"))
+	resourceVersion, err := srv.relistNodes(work)
 	if err != nil {
 		return err
 	}
 
+	watcher, err := kube.ResumeNodeWatcher(ctx, srv.GetKubeClient(), label, resourceVersion)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if watcher != nil {
+			watcher.Stop()
+		}
+	}()
+
+	reconcile, stop := srv.reconcileTicker()
+	defer stop()
+
+	renew, stopRenew := srv.renewalTicker()
+	defer stopRenew()
+
+	retry, stopRetry := srv.pendingTicker()
+	defer stopRetry()
+
 	for {
 		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-reconcile:
+			srv.runReconcile(work)
+		case <-srv.shard.changed():
+			// take over the nodes of replicas that left
+			srv.runReconcile(work)
+		case <-renew:
+			_ = srv.RenewSilences(work)
+		case <-retry:
+			if err := srv.RetryPending(work); err != nil {
+				srv.logger.Errorw("unable to retry pending nodes", "error", err)
+			}
 		case event, ok := <-watcher.ResultChan():
-			if !ok {
-				srv.logger.Info("refreshing watcher...")
+			if ok && event.Type == watch.Error {
+				err := apierrors.FromObject(event.Object)
+				if !isExpired(err) {
+					return err
+				}
+
+				srv.logger.Infow("resource version expired, relisting nodes...", "resourceVersion", resourceVersion)
+
+				if resourceVersion, err = srv.relistNodes(work); err != nil {
+					return err
+				}
+			}
+
+			if !ok || event.Type == watch.Error {
+				srv.logger.Debugw("resuming watcher...", "resourceVersion", resourceVersion)
 				watcher.Stop()
-				if watcher, err = kube.NewNodeWatcher(ctx, srv.GetKubeClient(), viper.GetString("kured-label")); err != nil {
+
+				watcher, err = kube.ResumeNodeWatcher(ctx, srv.GetKubeClient(), label, resourceVersion)
+				if isExpired(err) {
+					if resourceVersion, err = srv.relistNodes(work); err != nil {
+						return err
+					}
+
+					watcher, err = kube.ResumeNodeWatcher(ctx, srv.GetKubeClient(), label, resourceVersion)
+				}
+
+				if err != nil {
 					return err
 				}
 
 				continue
 			}
 
-			srv.EventHandler(ctx, event)
+			if obj, err := meta.Accessor(event.Object); err == nil {
+				resourceVersion = obj.GetResourceVersion()
+			}
+
+			if event.Type == watch.Bookmark {
+				continue
+			}
+
+			srv.EventHandler(work, event)
 		}
 	}
 }
 
-func isNodeReady(node *v1.Node) error {
+// relistNodes lists the labelled nodes, handling each of them as added and every silenced
+// node that is no longer labelled as deleted. It returns the resource version of the list
+// for the watch to start from.
+func (srv *Server) relistNodes(ctx context.Context) (string, error) {
+	nodes, err := kube.ListNodes(ctx, srv.GetKubeClient(), viper.GetString("kured-label"))
+	if err != nil {
+		return "", err
+	}
+
+	labelled := make(map[string]bool, len(nodes.Items))
 
-	if node.Spec.Unschedulable {
-		return ErrNodeUnschedulable
+	for i := range nodes.Items {
+		labelled[nodes.Items[i].Name] = true
+
+		srv.EventHandler(ctx, watch.Event{Type: watch.Added, Object: &nodes.Items[i]})
 	}
 
-	for _, c := range node.Status.Conditions {
-		if c.Type == v1.NodeReady {
-			return nil
+	silenced, err := srv.state.List(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for node := range silenced {
+		if !labelled[node] {
+			srv.EventHandler(ctx, watch.Event{
+				Type:   watch.Deleted,
+				Object: &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: node}},
+			})
 		}
 	}
-	return ErrNodeNotReady
+
+	return nodes.ResourceVersion, nil
+}
+
+// isExpired reports whether the error is the api server rejecting a resource version that is too old
+func isExpired(err error) bool {
+	return apierrors.IsResourceExpired(err) || apierrors.IsGone(err)
 }
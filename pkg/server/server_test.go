@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"net/url"
 	"testing"
+	"time"
 
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/tylerauerbeck/kured-silencer/pkg/alertmanager"
@@ -14,6 +17,7 @@ import (
 	"go.uber.org/zap"
 
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/fake"
 )
@@ -99,7 +103,7 @@ func TestEventHandler(t *testing.T) {
 			KubeClient: fake.NewSimpleClientset(),
 			AMClient:   amc,
 		},
-	}.WithLogger(ctx, zap.NewNop().Sugar()).WithSilenceDuration(ctx, 5)
+	}.WithLogger(ctx, zap.NewNop().Sugar()).WithSilenceDuration(ctx, 5).WithStateStore(ctx, server.NewMemoryStateStore())
 
 	event := watch.Event{}
 
@@ -107,7 +111,19 @@ func TestEventHandler(t *testing.T) {
 	assert.NoError(t, err)
 
 	event.Type = watch.Added
-	event.Object = &v1.Node{}
+	event.Object = &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+		},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{
+				{
+					Type:   v1.NodeReady,
+					Status: v1.ConditionTrue,
+				},
+			},
+		},
+	}
 
 	err = srv.EventHandler(ctx, event)
 	assert.NoError(t, err)
@@ -116,3 +132,184 @@ func TestEventHandler(t *testing.T) {
 
 	assert.NoError(t, err)
 }
+
+func TestRunDropsStaleState(t *testing.T) {
+	viper.Set("kured-label", "silence=true")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, amc := newFakeAlertManager(t, func() models.GettableAlerts { return models.GettableAlerts{} })
+
+	// the silences of node-1 were removed while another replica was leading
+	state := server.NewMemoryStateStore()
+	assert.NoError(t, state.Set(ctx, "node-1", server.NodeState{SilenceIDs: []string{"id-1"}}))
+
+	// the silences of node-2 ended after it got stuck, it is not silenced again
+	assert.NoError(t, state.Set(ctx, "node-2", server.NodeState{SilenceIDs: []string{"id-2"}, Stuck: true}))
+
+	srv := server.Server{
+		Client: &server.Client{
+			KubeClient: fake.NewSimpleClientset(),
+			AMClient:   amc,
+		},
+	}.WithLogger(ctx, zap.NewNop().Sugar()).
+		WithStateStore(ctx, state).
+		WithRemovalBuffer(ctx, time.Hour)
+
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		srv.Run(ctx)
+	}()
+
+	assert.Eventually(t, func() bool {
+		nodes, err := state.List(ctx)
+		return err == nil && len(nodes) == 1 && len(nodes["node-2"].SilenceIDs) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	ns, ok, err := state.Get(ctx, "node-2")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, ns.Stuck)
+	assert.Empty(t, ns.SilenceIDs)
+
+	cancel()
+	<-stopped
+}
+
+func TestRunRemovesSilencesOfUnlabelledNodes(t *testing.T) {
+	viper.Set("kured-label", "silence=true")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// node-1 lost the label while no replica was running
+	fam, amc := newFakeAlertManager(t, func() models.GettableAlerts { return models.GettableAlerts{} })
+	fam.nodeSilence("id-1", "node-1")
+
+	state := server.NewMemoryStateStore()
+	assert.NoError(t, state.Set(ctx, "node-1", server.NodeState{SilenceIDs: []string{"id-1"}}))
+
+	srv := server.Server{
+		Client: &server.Client{
+			KubeClient: fake.NewSimpleClientset(),
+			AMClient:   amc,
+		},
+	}.WithLogger(ctx, zap.NewNop().Sugar()).
+		WithStateStore(ctx, state).
+		WithRemovalBuffer(ctx, 0)
+
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		srv.Run(ctx)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return len(fam.deletedSilences()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, []string{"id-1"}, fam.deletedSilences())
+
+	cancel()
+	<-stopped
+}
+
+func TestRunRestoresRemoveWhen(t *testing.T) {
+	viper.Set("kured-label", "silence=true")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	silences := []alertmanager.Silence{
+		{Matchers: []alertmanager.Matcher{{Name: "node", Value: "{{ .Name }}"}}, RemoveWhen: `up{node="{{ .Name }}"} == 0`},
+		{Matchers: []alertmanager.Matcher{{Name: "kubernetes_node", Value: "{{ .Name }}"}}},
+	}
+
+	// node-1 was silenced by a previous leader and lost the label since
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+
+	kcli := fake.NewSimpleClientset(node)
+	_, amc := newFakeAlertManager(t, func() models.GettableAlerts { return models.GettableAlerts{} })
+
+	newServer := func(state server.StateStore) *server.Server {
+		return server.Server{
+			Client: &server.Client{
+				KubeClient: kcli,
+				AMClient:   amc,
+			},
+		}.WithLogger(ctx, zap.NewNop().Sugar()).
+			WithStateStore(ctx, state).
+			WithSilences(ctx, silences).
+			WithRemovalBuffer(ctx, time.Hour)
+	}
+
+	previous := server.NewMemoryStateStore()
+	assert.NoError(t, newServer(previous).EventHandler(ctx, watch.Event{Type: watch.Added, Object: node}))
+
+	expected, ok, err := previous.Get(ctx, node.Name)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Len(t, expected.RemoveWhen, 1)
+
+	// the queries are restored along with the silences by a replica without the state
+	state := server.NewMemoryStateStore()
+	srv := newServer(state)
+
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		srv.Run(ctx)
+	}()
+
+	assert.Eventually(t, func() bool {
+		ns, ok, err := state.Get(ctx, node.Name)
+		return err == nil && ok && len(ns.RemoveWhen) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	ns, _, err := state.Get(ctx, node.Name)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, expected.SilenceIDs, ns.SilenceIDs)
+	assert.Equal(t, expected.RemoveWhen, ns.RemoveWhen)
+
+	cancel()
+	<-stopped
+}
+
+func TestSilencesAreNotSharedBetweenNodes(t *testing.T) {
+	ctx := context.Background()
+
+	_, amc := newFakeAlertManager(t, func() models.GettableAlerts { return models.GettableAlerts{} })
+	state := server.NewMemoryStateStore()
+
+	srv := server.Server{
+		Client: &server.Client{
+			KubeClient: fake.NewSimpleClientset(),
+			AMClient:   amc,
+		},
+	}.WithLogger(ctx, zap.NewNop().Sugar()).
+		WithStateStore(ctx, state).
+		WithSilences(ctx, []alertmanager.Silence{
+			{Matchers: []alertmanager.Matcher{{Name: "alertname", Value: "KubeletDown"}}},
+		})
+
+	for _, name := range []string{"node-1", "node-2"} {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		assert.NoError(t, srv.EventHandler(ctx, watch.Event{Type: watch.Added, Object: node}))
+	}
+
+	// each node gets a silence of its own, even with matchers that do not name the node
+	first, _, err := state.Get(ctx, "node-1")
+	assert.NoError(t, err)
+
+	second, _, err := state.Get(ctx, "node-2")
+	assert.NoError(t, err)
+
+	assert.Len(t, first.SilenceIDs, 1)
+	assert.Len(t, second.SilenceIDs, 1)
+	assert.NotEqual(t, first.SilenceIDs, second.SilenceIDs)
+}
@@ -0,0 +1,323 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// shardLabel marks the per-replica Leases, its value is the lock name replicas share
+	shardLabel = "kured-silencer/shard"
+
+	// ringReplicas is the number of points each replica has on the hash ring, spreading
+	// nodes evenly and moving few of them when replicas come and go
+	ringReplicas = 100
+)
+
+// shard splits nodes between live replicas by consistent hashing. Each replica renews a
+// Lease of its own, replicas whose Lease was not renewed within the lease duration are
+// considered dead and their nodes are taken over by the others. A replica that failed to
+// renew its own Lease within the lease duration owns no nodes until it renews it again.
+// A nil shard owns every node.
+type shard struct {
+	cli           kubernetes.Interface
+	namespace     string
+	group         string
+	identity      string
+	leaseDuration time.Duration
+	renewInterval time.Duration
+	logger        *zap.SugaredLogger
+
+	mu      sync.RWMutex
+	members []string
+	ring    *hashRing
+	renewed time.Time
+	changes chan struct{}
+}
+
+// newShard returns the shard of this replica, using the lease settings of the leader election
+func newShard(cli kubernetes.Interface, le LeaderElection, logger *zap.SugaredLogger) *shard {
+	return &shard{
+		logger:        logger,
+		cli:           cli,
+		namespace:     le.Namespace,
+		group:         le.LockName,
+		identity:      le.Identity,
+		leaseDuration: le.LeaseDuration,
+		renewInterval: le.RetryPeriod,
+		changes:       make(chan struct{}, 1),
+	}
+}
+
+// run renews the Lease of this replica and tracks the live replicas until ctx is cancelled,
+// then deletes the Lease so the other replicas take over right away. ready is closed once
+// the replicas have been observed for the first time.
+func (s *shard) run(ctx context.Context, ready chan<- struct{}) {
+	ticker := time.NewTicker(s.renewInterval)
+	defer ticker.Stop()
+
+	defer func() {
+		// ctx is already cancelled
+		if err := s.cli.CoordinationV1().Leases(s.namespace).Delete(context.Background(), s.leaseName(), metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			s.logger.Errorw("unable to delete replica lease", "lease", s.leaseName(), "error", err)
+		}
+	}()
+
+	for {
+		if err := s.sync(ctx); err != nil {
+			s.logger.Errorw("unable to sync replicas", "error", err)
+		} else if ready != nil {
+			close(ready)
+			ready = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync renews the Lease of this replica and rebuilds the ring if the live replicas changed
+func (s *shard) sync(ctx context.Context) error {
+	// the Lease counts as renewed from before the request, the other replicas may read
+	// the new renew time any time after
+	started := time.Now()
+
+	if err := s.renew(ctx); err != nil {
+		return err
+	}
+
+	members, err := s.liveMembers(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the nodes were given up while the Lease was stale, they are taken back like those
+	// of replicas that left
+	stale := !s.fresh(started)
+	s.renewed = started
+
+	if s.ring != nil && equalMembers(s.members, members) && !stale {
+		return nil
+	}
+
+	s.members = members
+	s.ring = newHashRing(members, ringReplicas)
+
+	s.logger.Infow("replicas changed", "replicas", members)
+
+	select {
+	case s.changes <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (s *shard) leaseName() string {
+	return s.group + "-" + s.identity
+}
+
+// renew creates or renews the Lease of this replica
+func (s *shard) renew(ctx context.Context) error {
+	leases := s.cli.CoordinationV1().Leases(s.namespace)
+	now := metav1.NewMicroTime(time.Now())
+	holder := s.identity
+	duration := int32(s.leaseDuration.Seconds())
+
+	lease, err := leases.Get(ctx, s.leaseName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.leaseName(),
+				Namespace: s.namespace,
+				Labels:    map[string]string{shardLabel: s.group},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+
+		return err
+	}
+
+	if err != nil {
+		return err
+	}
+
+	lease.Spec.HolderIdentity = &holder
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.RenewTime = &now
+
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+
+	return err
+}
+
+// liveMembers returns the sorted identities of the replicas whose Lease has not expired
+func (s *shard) liveMembers(ctx context.Context) ([]string, error) {
+	leases, err := s.cli.CoordinationV1().Leases(s.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", shardLabel, s.group),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	members := []string{s.identity}
+
+	for _, lease := range leases.Items {
+		spec := lease.Spec
+		if spec.HolderIdentity == nil || *spec.HolderIdentity == s.identity || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+			continue
+		}
+
+		expires := spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second)
+		if now.Before(expires) {
+			members = append(members, *spec.HolderIdentity)
+		}
+	}
+
+	sort.Strings(members)
+
+	return members, nil
+}
+
+// owns reports whether this replica handles the node
+func (s *shard) owns(node string) bool {
+	if s == nil {
+		return true
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// the other replicas may already consider this replica dead
+	if !s.fresh(time.Now()) {
+		return false
+	}
+
+	return s.ring != nil && s.ring.owner(node) == s.identity
+}
+
+// fresh reports whether the Lease of this replica was renewed within the lease duration
+// before now, the caller holds the lock
+func (s *shard) fresh(now time.Time) bool {
+	return !s.renewed.IsZero() && now.Sub(s.renewed) < s.leaseDuration
+}
+
+// changed returns a channel that receives when the live replicas change, a nil shard
+// never changes
+func (s *shard) changed() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+
+	return s.changes
+}
+
+// runSharded handles the nodes owned by this replica until ctx is cancelled. The Lease of
+// the replica is only deleted once it stopped handling nodes.
+func (srv *Server) runSharded(ctx context.Context) {
+	shardCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ready := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		srv.shard.run(shardCtx, ready)
+	}()
+
+	select {
+	case <-ready:
+		srv.lead(ctx, context.Background())
+	case <-ctx.Done():
+	}
+
+	srv.shutdown()
+
+	cancel()
+	<-stopped
+}
+
+func equalMembers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hashRing maps keys to members by consistent hashing
+type hashRing struct {
+	points []uint32
+	owners map[uint32]string
+}
+
+func newHashRing(members []string, replicas int) *hashRing {
+	r := &hashRing{owners: make(map[uint32]string, len(members)*replicas)}
+
+	for _, m := range members {
+		for i := 0; i < replicas; i++ {
+			p := hashKey(m + "#" + strconv.Itoa(i))
+			r.points = append(r.points, p)
+			r.owners[p] = m
+		}
+	}
+
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+
+	return r
+}
+
+// owner returns the member owning the key, the first point on the ring after its hash
+func (r *hashRing) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	h := hashKey(key)
+
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.owners[r.points[i]]
+}
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+
+	return h.Sum32()
+}
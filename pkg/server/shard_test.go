@@ -0,0 +1,191 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/server"
+
+	"go.uber.org/zap"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSharding(t *testing.T) {
+	viper.Set("kured-label", "silence=true")
+
+	kcli := fake.NewSimpleClientset()
+	_, amc := newFakeAlertManager(t, func() models.GettableAlerts { return models.GettableAlerts{} })
+
+	replica := func(ctx context.Context, identity string) (server.StateStore, <-chan struct{}) {
+		state := server.NewMemoryStateStore()
+
+		srv := server.Server{
+			Client: &server.Client{
+				KubeClient: kcli,
+				AMClient:   amc,
+			},
+		}.WithLogger(ctx, zap.NewNop().Sugar()).
+			WithStateStore(ctx, state).
+			WithRemovalBuffer(ctx, time.Hour).
+			WithSharding(ctx, server.LeaderElection{
+				LockName:      "kured-silencer",
+				Namespace:     "default",
+				Identity:      identity,
+				LeaseDuration: time.Second,
+				RetryPeriod:   20 * time.Millisecond,
+			})
+
+		stopped := make(chan struct{})
+
+		go func() {
+			defer close(stopped)
+			srv.Run(ctx)
+		}()
+
+		return state, stopped
+	}
+
+	ctx := context.Background()
+
+	ctxA, cancelA := context.WithCancel(ctx)
+	defer cancelA()
+
+	ctxB, cancelB := context.WithCancel(ctx)
+	defer cancelB()
+
+	stateA, _ := replica(ctxA, "replica-a")
+	stateB, stoppedB := replica(ctxB, "replica-b")
+
+	assert.Eventually(t, func() bool {
+		leases, err := kcli.CoordinationV1().Leases("default").List(ctx, metav1.ListOptions{})
+		return err == nil && len(leases.Items) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// give both replicas time to observe each other
+	time.Sleep(100 * time.Millisecond)
+
+	for i := 0; i < 20; i++ {
+		_, err := kcli.CoreV1().Nodes().Create(ctx, &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node-%d", i), Labels: map[string]string{"silence": "true"}},
+		}, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+
+	// every node is silenced by exactly one replica
+	assert.Eventually(t, func() bool {
+		a, _ := stateA.List(ctx)
+		b, _ := stateB.List(ctx)
+
+		return len(a)+len(b) == 20
+	}, 10*time.Second, 10*time.Millisecond)
+
+	a, err := stateA.List(ctx)
+	assert.NoError(t, err)
+
+	b, err := stateB.List(ctx)
+	assert.NoError(t, err)
+
+	assert.NotEmpty(t, a)
+	assert.NotEmpty(t, b)
+
+	for node := range a {
+		assert.NotContains(t, b, node)
+	}
+
+	// the remaining replica takes over the nodes of a replica that stopped
+	cancelB()
+	<-stoppedB
+
+	assert.Eventually(t, func() bool {
+		a, _ := stateA.List(ctx)
+		return len(a) == 20
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestShardingStaleLease(t *testing.T) {
+	viper.Set("kured-label", "silence=true")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var failing atomic.Bool
+
+	kcli := fake.NewSimpleClientset()
+	kcli.PrependReactor("update", "leases", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failing.Load() {
+			return true, nil, errors.New("api server unavailable")
+		}
+
+		return false, nil, nil
+	})
+
+	_, amc := newFakeAlertManager(t, func() models.GettableAlerts { return models.GettableAlerts{} })
+	state := server.NewMemoryStateStore()
+
+	srv := server.Server{
+		Client: &server.Client{
+			KubeClient: kcli,
+			AMClient:   amc,
+		},
+	}.WithLogger(ctx, zap.NewNop().Sugar()).
+		WithStateStore(ctx, state).
+		WithRemovalBuffer(ctx, time.Hour).
+		WithSharding(ctx, server.LeaderElection{
+			LockName:      "kured-silencer",
+			Namespace:     "default",
+			Identity:      "replica-a",
+			LeaseDuration: 200 * time.Millisecond,
+			RetryPeriod:   20 * time.Millisecond,
+		})
+
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		srv.Run(ctx)
+	}()
+
+	createNode := func(name string) {
+		_, err := kcli.CoreV1().Nodes().Create(ctx, &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"silence": "true"}},
+		}, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+
+	silenced := func(name string) func() bool {
+		return func() bool {
+			_, ok, err := state.Get(ctx, name)
+			return err == nil && ok
+		}
+	}
+
+	createNode("node-1")
+	assert.Eventually(t, silenced("node-1"), 5*time.Second, 10*time.Millisecond)
+
+	// nodes are not handled while the replica can not renew its own lease
+	failing.Store(true)
+	time.Sleep(300 * time.Millisecond)
+
+	createNode("node-2")
+	assert.Never(t, silenced("node-2"), 200*time.Millisecond, 10*time.Millisecond)
+
+	// they are taken back once the lease is renewed again
+	failing.Store(false)
+	assert.Eventually(t, silenced("node-2"), 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-stopped
+}
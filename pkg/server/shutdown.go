@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// OnShutdownKeep leaves active silences in place on shutdown, the next leader restores
+	// them from alertmanager and removes them once their nodes lose the label
+	OnShutdownKeep = "keep"
+
+	// OnShutdownExpire expires the silences of every node on shutdown
+	OnShutdownExpire = "expire"
+)
+
+// drainContext returns a context for work started while ctx is active. It is cancelled
+// once the shutdown timeout has passed after ctx is cancelled, or when cancel is called,
+// so alertmanager calls in flight on shutdown get to complete. It is cancelled right away
+// along with lease, as another replica may already be handling the same nodes.
+func (srv Server) drainContext(ctx, lease context.Context) (context.Context, context.CancelFunc) {
+	work, cancel := context.WithCancel(lease)
+
+	go func() {
+		select {
+		case <-work.Done():
+			return
+		case <-ctx.Done():
+		}
+
+		timer := time.NewTimer(srv.shutdownTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-work.Done():
+		case <-timer.C:
+			cancel()
+		}
+	}()
+
+	return work, cancel
+}
+
+// stepDown stops pending silence removals and waits for running ones for at most the
+// shutdown timeout. The silences are left for the next leader to remove, along with the
+// queued alertmanager retries.
+func (srv Server) stepDown() {
+	ctx, cancel := context.WithTimeout(context.Background(), srv.shutdownTimeout)
+	defer cancel()
+
+	if !srv.removals.stop(ctx) {
+		srv.logger.Warnw("silence removals still running after shutdown timeout", "timeout", srv.shutdownTimeout)
+	}
+
+	if dropped := srv.retries.reset(); dropped > 0 {
+		srv.logger.Warnw("dropped queued alertmanager retries on step down", "operations", dropped)
+	}
+}
+
+// shutdown expires the silences of every node if configured to
+func (srv Server) shutdown() {
+	if srv.onShutdown != OnShutdownExpire {
+		srv.logger.Info("shutting down, keeping active silences")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), srv.shutdownTimeout)
+	defer cancel()
+
+	nodes, err := srv.state.List(ctx)
+	if err != nil {
+		srv.logger.Errorw("unable to list silenced nodes on shutdown", "error", err)
+		return
+	}
+
+	for node, state := range nodes {
+		if !srv.shard.owns(node) {
+			continue
+		}
+
+		if err := srv.expireSilences(ctx, node, state.SilenceIDs); err != nil {
+			srv.logger.Errorw("unable to expire silences on shutdown", "node", node, "error", err)
+		}
+	}
+
+	srv.logger.Infow("shutting down, expired active silences", "nodes", len(nodes))
+}
@@ -0,0 +1,81 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/server"
+
+	"go.uber.org/zap"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestShutdown(t *testing.T) {
+	viper.Set("kured-label", "silence=true")
+
+	type testCase struct {
+		name       string
+		onShutdown string
+		expected   []string
+	}
+
+	testCases := []testCase{
+		{
+			name:       "keep",
+			onShutdown: server.OnShutdownKeep,
+			expected:   []string{},
+		},
+		{
+			name:       "expire",
+			onShutdown: server.OnShutdownExpire,
+			expected:   []string{"id-1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			fam, amc := newFakeAlertManager(t, func() models.GettableAlerts { return models.GettableAlerts{} })
+
+			fam.nodeSilence("id-1", "node-1")
+
+			state := server.NewMemoryStateStore()
+
+			srv := server.Server{
+				Client: &server.Client{
+					KubeClient: fake.NewSimpleClientset(),
+					AMClient:   amc,
+				},
+			}.WithLogger(ctx, zap.NewNop().Sugar()).
+				WithStateStore(ctx, state).
+				WithRemovalBuffer(ctx, time.Hour).
+				WithShutdown(ctx, tc.onShutdown, time.Second)
+
+			stopped := make(chan struct{})
+
+			go func() {
+				defer close(stopped)
+				srv.Run(ctx)
+			}()
+
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+
+			select {
+			case <-stopped:
+			case <-time.After(2 * time.Second):
+				t.Fatal("server did not stop after cancellation")
+			}
+
+			assert.Equal(t, tc.expected, fam.deletedSilences())
+		})
+	}
+}
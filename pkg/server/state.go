@@ -0,0 +1,323 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// StateStoreMemory keeps state in memory, it is lost on restart or failover
+	StateStoreMemory = "memory"
+
+	// StateStoreConfigMap keeps state in a ConfigMap, one key per node
+	StateStoreConfigMap = "configmap"
+
+	// StateStoreLease keeps state in an annotation on a Lease
+	StateStoreLease = "lease"
+
+	stateAnnotation = "kured-silencer/state"
+)
+
+// NodeState is the state kept for a silenced node
+type NodeState struct {
+	SilenceIDs []string `json:"silenceIDs"`
+	// RemoveWhen maps silence ids to the rendered query that has to return no samples
+	// before the silence is removed
+	RemoveWhen map[string]string `json:"removeWhen,omitempty"`
+	// BootID is the boot id of the node when it was silenced, a different boot id
+	// once the label is removed proves the node rebooted
+	BootID string `json:"bootID,omitempty"`
+	// SilencedAt is when the node was silenced, silences are not renewed past the
+	// maximum silence duration from then
+	SilencedAt time.Time `json:"silencedAt,omitempty"`
+	// Stuck is set once the node reached the maximum silence duration
+	Stuck bool `json:"stuck,omitempty"`
+}
+
+// StateStore keeps track of the silences created for each node. Implementations
+// must be safe for concurrent use.
+type StateStore interface {
+	// Get returns the state of the node and whether it exists
+	Get(ctx context.Context, node string) (NodeState, bool, error)
+	// Set stores the state of the node
+	Set(ctx context.Context, node string, state NodeState) error
+	// Delete removes the state of the node
+	Delete(ctx context.Context, node string) error
+	// List returns the state of every node
+	List(ctx context.Context) (map[string]NodeState, error)
+}
+
+// NewStateStore returns the state store of the given kind, kubernetes backed stores
+// keep their state in the named object in the given namespace
+func NewStateStore(kind string, cli kubernetes.Interface, namespace, name string) (StateStore, error) {
+	switch kind {
+	case StateStoreMemory, "":
+		return NewMemoryStateStore(), nil
+	case StateStoreConfigMap:
+		if namespace == "" {
+			return nil, ErrMissingNamespace
+		}
+
+		return NewConfigMapStateStore(cli, namespace, name), nil
+	case StateStoreLease:
+		if namespace == "" {
+			return nil, ErrMissingNamespace
+		}
+
+		return NewLeaseStateStore(cli, namespace, name), nil
+	default:
+		return nil, ErrUnknownStateStore
+	}
+}
+
+type memoryStateStore struct {
+	mu    sync.RWMutex
+	nodes map[string]NodeState
+}
+
+// NewMemoryStateStore returns a state store that only keeps state in memory
+func NewMemoryStateStore() StateStore {
+	return &memoryStateStore{
+		nodes: make(map[string]NodeState),
+	}
+}
+
+func (s *memoryStateStore) Get(_ context.Context, node string) (NodeState, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, ok := s.nodes[node]
+
+	return state, ok, nil
+}
+
+func (s *memoryStateStore) Set(_ context.Context, node string, state NodeState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nodes[node] = state
+
+	return nil
+}
+
+func (s *memoryStateStore) Delete(_ context.Context, node string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.nodes, node)
+
+	return nil
+}
+
+func (s *memoryStateStore) List(_ context.Context) (map[string]NodeState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nodes := make(map[string]NodeState, len(s.nodes))
+	for node, state := range s.nodes {
+		nodes[node] = state
+	}
+
+	return nodes, nil
+}
+
+// stateObject is a kubernetes object that the state of all nodes is read from and
+// written back to as a whole
+type stateObject interface {
+	// load fetches the object, creating it if it does not exist yet
+	load(ctx context.Context) (map[string]NodeState, error)
+	// save writes the state back to the last loaded version of the object
+	save(ctx context.Context, nodes map[string]NodeState) error
+}
+
+type kubeStateStore struct {
+	mu     sync.Mutex
+	object stateObject
+}
+
+// NewConfigMapStateStore returns a state store that keeps the state of each node
+// as a key of the named ConfigMap
+func NewConfigMapStateStore(cli kubernetes.Interface, namespace, name string) StateStore {
+	return &kubeStateStore{
+		object: &configMapObject{cli: cli, namespace: namespace, name: name},
+	}
+}
+
+// NewLeaseStateStore returns a state store that keeps the state of all nodes in an
+// annotation on the named Lease
+func NewLeaseStateStore(cli kubernetes.Interface, namespace, name string) StateStore {
+	return &kubeStateStore{
+		object: &leaseObject{cli: cli, namespace: namespace, name: name},
+	}
+}
+
+func (s *kubeStateStore) Get(ctx context.Context, node string) (NodeState, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nodes, err := s.object.load(ctx)
+	if err != nil {
+		return NodeState{}, false, err
+	}
+
+	state, ok := nodes[node]
+
+	return state, ok, nil
+}
+
+func (s *kubeStateStore) Set(ctx context.Context, node string, state NodeState) error {
+	return s.update(ctx, func(nodes map[string]NodeState) {
+		nodes[node] = state
+	})
+}
+
+func (s *kubeStateStore) Delete(ctx context.Context, node string) error {
+	return s.update(ctx, func(nodes map[string]NodeState) {
+		delete(nodes, node)
+	})
+}
+
+func (s *kubeStateStore) List(ctx context.Context) (map[string]NodeState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.object.load(ctx)
+}
+
+func (s *kubeStateStore) update(ctx context.Context, fn func(map[string]NodeState)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		nodes, err := s.object.load(ctx)
+		if err != nil {
+			return err
+		}
+
+		fn(nodes)
+
+		return s.object.save(ctx, nodes)
+	})
+}
+
+type configMapObject struct {
+	cli       kubernetes.Interface
+	namespace string
+	name      string
+	cm        *v1.ConfigMap
+}
+
+func (o *configMapObject) load(ctx context.Context) (map[string]NodeState, error) {
+	cm, err := o.cli.CoreV1().ConfigMaps(o.namespace).Get(ctx, o.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm, err = o.cli.CoreV1().ConfigMaps(o.namespace).Create(ctx, &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: o.name, Namespace: o.namespace},
+		}, metav1.CreateOptions{})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	o.cm = cm
+
+	nodes := make(map[string]NodeState, len(cm.Data))
+
+	for node, data := range cm.Data {
+		state := NodeState{}
+		if err := json.Unmarshal([]byte(data), &state); err != nil {
+			return nil, err
+		}
+
+		nodes[node] = state
+	}
+
+	return nodes, nil
+}
+
+func (o *configMapObject) save(ctx context.Context, nodes map[string]NodeState) error {
+	cm := o.cm.DeepCopy()
+	cm.Data = make(map[string]string, len(nodes))
+
+	for node, state := range nodes {
+		data, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+
+		cm.Data[node] = string(data)
+	}
+
+	cm, err := o.cli.CoreV1().ConfigMaps(o.namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+
+	o.cm = cm
+
+	return nil
+}
+
+type leaseObject struct {
+	cli       kubernetes.Interface
+	namespace string
+	name      string
+	lease     *coordinationv1.Lease
+}
+
+func (o *leaseObject) load(ctx context.Context) (map[string]NodeState, error) {
+	lease, err := o.cli.CoordinationV1().Leases(o.namespace).Get(ctx, o.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease, err = o.cli.CoordinationV1().Leases(o.namespace).Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: o.name, Namespace: o.namespace},
+		}, metav1.CreateOptions{})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	o.lease = lease
+
+	nodes := make(map[string]NodeState)
+
+	if data, ok := lease.Annotations[stateAnnotation]; ok && data != "" {
+		if err := json.Unmarshal([]byte(data), &nodes); err != nil {
+			return nil, err
+		}
+	}
+
+	return nodes, nil
+}
+
+func (o *leaseObject) save(ctx context.Context, nodes map[string]NodeState) error {
+	data, err := json.Marshal(nodes)
+	if err != nil {
+		return err
+	}
+
+	lease := o.lease.DeepCopy()
+	if lease.Annotations == nil {
+		lease.Annotations = make(map[string]string)
+	}
+
+	lease.Annotations[stateAnnotation] = string(data)
+
+	lease, err = o.cli.CoordinationV1().Leases(o.namespace).Update(ctx, lease, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+
+	o.lease = lease
+
+	return nil
+}
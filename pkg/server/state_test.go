@@ -0,0 +1,92 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/server"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStateStores(t *testing.T) {
+	testCases := []string{
+		server.StateStoreMemory,
+		server.StateStoreConfigMap,
+		server.StateStoreLease,
+	}
+
+	for _, kind := range testCases {
+		t.Run(kind, func(t *testing.T) {
+			ctx := context.Background()
+			cli := fake.NewSimpleClientset()
+
+			store, err := server.NewStateStore(kind, cli, "kured", "kured-silencer-state")
+			assert.NoError(t, err)
+
+			_, ok, err := store.Get(ctx, "node-1")
+			assert.NoError(t, err)
+			assert.False(t, ok)
+
+			state := server.NodeState{SilenceIDs: []string{"a", "b"}}
+			assert.NoError(t, store.Set(ctx, "node-1", state))
+
+			got, ok, err := store.Get(ctx, "node-1")
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, state, got)
+
+			// a second store on the same object picks up the existing state
+			if kind != server.StateStoreMemory {
+				other, err := server.NewStateStore(kind, cli, "kured", "kured-silencer-state")
+				assert.NoError(t, err)
+
+				got, ok, err = other.Get(ctx, "node-1")
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, state, got)
+			}
+
+			var wg sync.WaitGroup
+
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+
+				go func(i int) {
+					defer wg.Done()
+
+					assert.NoError(t, store.Set(ctx, fmt.Sprintf("node-%d", i+2), state))
+				}(i)
+			}
+
+			wg.Wait()
+
+			nodes, err := store.List(ctx)
+			assert.NoError(t, err)
+			assert.Len(t, nodes, 11)
+
+			assert.NoError(t, store.Delete(ctx, "node-1"))
+
+			_, ok, err = store.Get(ctx, "node-1")
+			assert.NoError(t, err)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestNewStateStore(t *testing.T) {
+	cli := fake.NewSimpleClientset()
+
+	_, err := server.NewStateStore("etcd", cli, "kured", "state")
+	assert.ErrorIs(t, err, server.ErrUnknownStateStore)
+
+	_, err = server.NewStateStore(server.StateStoreConfigMap, cli, "", "state")
+	assert.ErrorIs(t, err, server.ErrMissingNamespace)
+
+	_, err = server.NewStateStore(server.StateStoreMemory, cli, "", "state")
+	assert.NoError(t, err)
+}
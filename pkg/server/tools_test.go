@@ -2,12 +2,26 @@ package server_test
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/go-openapi/strfmt"
+	"github.com/prometheus/alertmanager/api/v2/client"
+	"github.com/prometheus/alertmanager/api/v2/models"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/alertmanager"
+	"github.com/tylerauerbeck/kured-silencer/pkg/internal/utils"
 )
 
 var (
@@ -42,6 +56,180 @@ func ephemeralAlertManager(ctx context.Context) {
 	AMContainer = amC
 }
 
+// fakeAlertManager is a minimal stand-in for the alertmanager api, serving the alerts
+// returned by alerts, keeping the end of posted silences and recording the ids of
+// deleted silences. While unavailable it answers every request with an error.
+type fakeAlertManager struct {
+	mu          sync.Mutex
+	alerts      func() models.GettableAlerts
+	silences    map[string]*models.GettableSilence
+	deleted     []string
+	unavailable bool
+	requests    int
+}
+
+func newFakeAlertManager(t *testing.T, alerts func() models.GettableAlerts) (*fakeAlertManager, *client.AlertmanagerAPI) {
+	fam := &fakeAlertManager{alerts: alerts, silences: map[string]*models.GettableSilence{}}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		fam.mu.Lock()
+		fam.requests++
+		unavailable := fam.unavailable
+		fam.mu.Unlock()
+
+		if unavailable {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v2/alerts":
+			_ = json.NewEncoder(w).Encode(fam.alerts())
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/v2/silence/"):
+			fam.mu.Lock()
+			_ = json.NewEncoder(w).Encode(fam.silence(strings.TrimPrefix(r.URL.Path, "/api/v2/silence/")))
+			fam.mu.Unlock()
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v2/silences":
+			fam.mu.Lock()
+			silences := models.GettableSilences{}
+			for _, s := range fam.silences {
+				silences = append(silences, s)
+			}
+			_ = json.NewEncoder(w).Encode(silences)
+			fam.mu.Unlock()
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v2/silences":
+			ps := models.PostableSilence{}
+			_ = json.NewDecoder(r.Body).Decode(&ps)
+
+			fam.mu.Lock()
+			if ps.ID == "" {
+				ps.ID = fmt.Sprintf("silence-%d", len(fam.silences)+1)
+			}
+
+			silence := fam.silence(ps.ID)
+			silence.EndsAt = ps.EndsAt
+
+			if ps.Comment != nil {
+				silence.Comment = ps.Comment
+			}
+
+			if ps.Matchers != nil {
+				silence.Matchers = ps.Matchers
+			}
+			fam.mu.Unlock()
+
+			_ = json.NewEncoder(w).Encode(map[string]string{"silenceID": ps.ID})
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/api/v2/silence/"):
+			id := strings.TrimPrefix(r.URL.Path, "/api/v2/silence/")
+
+			fam.mu.Lock()
+			fam.deleted = append(fam.deleted, id)
+			fam.silence(id).Status.State = utils.NewString(models.SilenceStatusStateExpired)
+			fam.mu.Unlock()
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	t.Cleanup(ts.Close)
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fam, alertmanager.NewSilencerClient(context.TODO(), u)
+}
+
+func (fam *fakeAlertManager) deletedSilences() []string {
+	fam.mu.Lock()
+	defer fam.mu.Unlock()
+
+	return append([]string{}, fam.deleted...)
+}
+
+// setUnavailable makes the fake answer every request with an error until it is reset
+func (fam *fakeAlertManager) setUnavailable(unavailable bool) {
+	fam.mu.Lock()
+	defer fam.mu.Unlock()
+
+	fam.unavailable = unavailable
+}
+
+// requestCount returns the number of requests the fake received
+func (fam *fakeAlertManager) requestCount() int {
+	fam.mu.Lock()
+	defer fam.mu.Unlock()
+
+	return fam.requests
+}
+
+// silence returns the silence with the given id, creating an active one if it is unknown.
+// Callers modifying the silence must hold the lock.
+func (fam *fakeAlertManager) silence(id string) *models.GettableSilence {
+	if s, ok := fam.silences[id]; ok {
+		return s
+	}
+
+	now := strfmt.DateTime(time.Now())
+
+	s := &models.GettableSilence{
+		ID:        utils.NewString(id),
+		Status:    &models.SilenceStatus{State: utils.NewString(models.SilenceStatusStateActive)},
+		UpdatedAt: &now,
+		Silence: models.Silence{
+			Comment:   utils.NewString("fake"),
+			CreatedBy: utils.NewString("kured-silencer"),
+			StartsAt:  &now,
+			EndsAt:    &now,
+			Matchers:  models.Matchers{},
+		},
+	}
+
+	fam.silences[id] = s
+
+	return s
+}
+
+// nodeSilence adds an active silence created by kured-silencer for the node
+func (fam *fakeAlertManager) nodeSilence(id, node string) {
+	fam.mu.Lock()
+	defer fam.mu.Unlock()
+
+	fam.silence(id).Comment = utils.NewString(fmt.Sprintf("fake [kured-silencer node=%s]", node))
+}
+
+// endsAt returns when the silence with the given id ends
+func (fam *fakeAlertManager) endsAt(id string) time.Time {
+	fam.mu.Lock()
+	defer fam.mu.Unlock()
+
+	return time.Time(*fam.silence(id).EndsAt)
+}
+
+func firingAlert(name string, silencedBy ...string) *models.GettableAlert {
+	now := strfmt.DateTime(time.Now())
+
+	return &models.GettableAlert{
+		Alert: models.Alert{
+			Labels: models.LabelSet{"alertname": name},
+		},
+		Annotations: models.LabelSet{},
+		EndsAt:      &now,
+		Fingerprint: &name,
+		Receivers:   []*models.Receiver{},
+		StartsAt:    &now,
+		UpdatedAt:   &now,
+		Status: &models.AlertStatus{
+			InhibitedBy: []string{},
+			SilencedBy:  silencedBy,
+			State:       utils.NewString(models.AlertStatusStateSuppressed),
+		},
+	}
+}
+
 func errPanic(msg string, err error) {
 	if err != nil {
 		log.Panicf("%s err: %s", msg, err.Error())
@@ -5,13 +5,19 @@ import (
 
 	"github.com/prometheus/alertmanager/api/v2/client"
 	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/tylerauerbeck/kured-silencer/pkg/alertmanager"
+	"github.com/tylerauerbeck/kured-silencer/pkg/prometheus"
 )
 
 // Client is a struct container the kubernetes and alertmanager clients
 type Client struct {
 	KubeClient kubernetes.Interface
 	AMClient   *client.AlertmanagerAPI
+	PromClient *prometheus.Client
 }
 
 // Server contains settings for kured-silencer
@@ -19,9 +25,31 @@ type Server struct {
 	Client *Client
 	// kubeClient      *kubernetes.Interface
 	// amClient        *client.AlertmanagerAPI
-	logger          *zap.SugaredLogger
-	removalBuffer   time.Duration
-	silenceDuration time.Duration
+	logger              *zap.SugaredLogger
+	removalBuffer       time.Duration
+	reconcileInterval   time.Duration
+	nodeWatcher         string
+	removals            *removalScheduler
+	removalStrategy     string
+	removalMaxWait      time.Duration
+	removalPollInterval time.Duration
+	recorder            record.EventRecorder
+	waitForPods         bool
+	podSelector         labels.Selector
+	podsTimeout         time.Duration
+	silenceDuration     time.Duration
+	silenceMaxDuration  time.Duration
+	silences            []alertmanager.Silence
+	preChecks           []PreCheck
+	pending             *pendingNodes
+	pendingInterval     time.Duration
+	onShutdown          string
+	shutdownTimeout     time.Duration
+	election            LeaderElection
+	shard               *shard
+	retries             *retryQueue
+	journal             Journal
+	state               StateStore
 
 	// silencedID string
 }